@@ -0,0 +1,70 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/golang/glog"
+	"github.com/michaeld/ecs-dns/lib"
+)
+
+// newClusters creates the configured clusters, sharing an instrumented session per region
+func newClusters(c *lib.Config, instrument ...func(*session.Session) *session.Session) lib.Clusters {
+
+	sessions := map[string]*session.Session{}
+	clusters := lib.Clusters{}
+
+	for _, cl := range c.Clusters {
+
+		s, found := sessions[cl.Region]
+
+		if !found {
+			var err error
+
+			if s, err = session.NewSession(&aws.Config{Region: aws.String(cl.Region)}); err != nil {
+				glog.Fatal(err)
+			}
+
+			lib.InstrumentSession(s)
+
+			for _, i := range instrument {
+				i(s)
+			}
+
+			sessions[cl.Region] = s
+		}
+
+		clusters = append(clusters, &lib.ECSCluster{
+			Region:    cl.Region,
+			Cluster:   cl.Name,
+			ECSClient: ecs.New(s),
+			EC2Client: ec2.New(s),
+			// targets filtered or named by service tags change without service metadata
+			RequireServices: len(c.Filter.ServiceTags) > 0 || strings.Contains(c.NameTemplate, ".ServiceTags"),
+		})
+	}
+
+	return clusters
+}
+
+// newBackend returns the targets of the configured clusters matched by the filters
+func newBackend(c *lib.Config, instrument ...func(*session.Session) *session.Session) lib.Backend {
+	return &lib.Filtered{Backend: newClusters(c, instrument...), Filter: c.Filter}
+}
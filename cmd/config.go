@@ -0,0 +1,187 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/michaeld/ecs-dns/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// secretKeys are masked when the configuration is printed
+var secretKeys = map[string]bool{"sinks.consul.token": true, "sinks.rfc2136.tsig-secret": true}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "inspect the configuration",
+}
+
+// configCheckCmd represents the config check command
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "validate the configuration, resolve the cluster and zone with read-only calls and print the effective configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		printConfig(os.Stdout)
+
+		ok := true
+
+		if err := configuration.Validate(); err != nil {
+			ok = false
+
+			for _, p := range err.(*lib.ConfigError).Problems {
+				fmt.Printf("invalid: %s\n", p)
+			}
+		}
+
+		if err := checkAWS(context.Background(), configuration); err != nil {
+			ok = false
+			fmt.Printf("error: %v\n", err)
+		}
+
+		if !ok {
+			os.Exit(1)
+		}
+
+		fmt.Println("configuration ok")
+	},
+}
+
+// printConfig writes the effective value of every setting with where it came from
+func printConfig(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+
+	for _, s := range settings {
+		v := fmt.Sprintf("%v", viper.Get(s.key))
+
+		if secretKeys[s.key] && v != "" {
+			v = "********"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.key, v, configSource(s.key, s.flag))
+	}
+
+	if viper.InConfig("clusters") {
+		fmt.Fprintf(w, "clusters\t%v\t%s\n", configuration.Clusters, configSource("clusters", ""))
+	}
+
+	w.Flush()
+}
+
+// configSource reports where viper took the value of a setting from, in viper's order of precedence
+func configSource(key, flag string) string {
+	if f := RootCmd.PersistentFlags().Lookup(flag); f != nil && f.Changed {
+		return "flag --" + flag
+	}
+
+	env := envPrefix + "_" + strings.NewReplacer(".", "_", "-", "_").Replace(strings.ToUpper(key))
+
+	if _, found := os.LookupEnv(env); found {
+		return "env " + env
+	}
+
+	if viper.InConfig(key) || viper.InConfig(flag) {
+		return "file " + viper.ConfigFileUsed()
+	}
+
+	return "default"
+}
+
+// checkAWS resolves the clusters and, for the route53 sink, the hosted zone without changing anything
+func checkAWS(ctx context.Context, c *lib.Config) error {
+
+	for _, cl := range c.Clusters {
+
+		s, err := session.NewSession(&aws.Config{Region: aws.String(cl.Region)})
+
+		if err != nil {
+			return err
+		}
+
+		o, err := ecs.New(s).DescribeClustersWithContext(ctx, &ecs.DescribeClustersInput{Clusters: []*string{aws.String(cl.Name)}})
+
+		if err != nil {
+			return err
+		}
+
+		if len(o.Clusters) == 0 || aws.StringValue(o.Clusters[0].Status) != "ACTIVE" {
+			return fmt.Errorf("cluster %s not found in %s", cl.Name, cl.Region)
+		}
+
+		fmt.Printf("cluster %s: %d container instances, %d running tasks\n", aws.StringValue(o.Clusters[0].ClusterArn),
+			aws.Int64Value(o.Clusters[0].RegisteredContainerInstancesCount), aws.Int64Value(o.Clusters[0].RunningTasksCount))
+	}
+
+	if len(c.Clusters) == 0 || !usesSink(c, "route53") || c.Domain == "" {
+		return nil
+	}
+
+	s, err := session.NewSession(&aws.Config{Region: aws.String(c.Region)})
+
+	if err != nil {
+		return err
+	}
+
+	zones, err := hostedZones(ctx, s, c)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("vpcs: %s\n", strings.Join(lib.VPCIDs(zones.VPCs), ", "))
+
+	if c.Zone != "" {
+		if err := zones.Verify(ctx, c.Zone, c.Domain); err != nil {
+			return err
+		}
+
+		fmt.Printf("zone %s hosts %s\n", c.Zone, c.Domain)
+
+		return nil
+	}
+
+	id, err := zones.Find(ctx, c.Domain, c.PrivateZone || c.CreateZone)
+
+	if err != nil && c.CreateZone {
+		fmt.Printf("zone: a private hosted zone would be created for %s (%v)\n", c.Domain, err)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("zone %s found for %s\n", id, c.Domain)
+
+	return nil
+}
+
+func init() {
+	configCmd.AddCommand(configCheckCmd)
+	RootCmd.AddCommand(configCmd)
+}
@@ -15,18 +15,18 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ecs"
-
 	"github.com/golang/glog"
 	"github.com/michaeld/ecs-dns/lib"
 	"github.com/mitchellh/hashstructure"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 )
 
@@ -37,40 +37,203 @@ var daemonCmd = &cobra.Command{
 	Short:   "prune, then run upserts daemonized",
 	Run: func(cmd *cobra.Command, args []string) {
 
+		validateConfig(configuration)
+
 		ticker := time.NewTicker(time.Second * time.Duration(configuration.Interval))
 		defer ticker.Stop()
 
-		go func(c *lib.Config) {
+		health := &lib.Health{
+			Interval:  time.Second * time.Duration(configuration.Interval),
+			Intervals: int(configuration.ReadyIntervals),
+		}
 
-			s, err := session.NewSession(&aws.Config{Region: aws.String(c.Region)})
+		// ctx is only cancelled when an in-flight reconcile outlives the shutdown timeout
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-			if err != nil {
-				glog.Fatal(err)
-			}
+		r, err := newReconciler(ctx, configuration, health)
+
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		elector, err := newElector(configuration, health)
+
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		reload := newReloader(ctx, r, health, elector)
+		reload.watch()
+
+		if configuration.ListenAddress != "" {
+			go func() {
+				http.Handle("/metrics", promhttp.Handler())
+				http.HandleFunc("/healthz", health.Healthz)
+				http.HandleFunc("/readyz", health.Readyz)
+				http.HandleFunc("/sinks", func(w http.ResponseWriter, req *http.Request) {
+					reload.get().sinks.ServeHTTP(w, req)
+				})
+
+				// sinks can come and go on reload so every sink name is routed to the current sinks
+				for _, name := range lib.SinkNames() {
+					name := name
+
+					http.HandleFunc("/"+name, func(w http.ResponseWriter, req *http.Request) {
+						d, _ := reload.get().sinks.Get(name)
+
+						if h, ok := d.(http.Handler); ok {
+							h.ServeHTTP(w, req)
+							return
+						}
+
+						http.NotFound(w, req)
+					})
+				}
+
+				glog.Infof("serving metrics and health checks on %s", configuration.ListenAddress)
+				glog.Fatal(http.ListenAndServe(configuration.ListenAddress, nil))
+			}()
+		}
+
+		// the lease is released once reconciling has stopped so a follower takes over right away
+		resign, elected := func() {}, make(chan struct{})
+
+		if elector != nil {
+			var electCtx context.Context
+			electCtx, resign = context.WithCancel(context.Background())
 
-			ecsClient := ecs.New(s)
-			ec2Client := ec2.New(s)
+			go func() {
+				defer close(elected)
+				elector.Run(electCtx)
+			}()
+		} else {
+			close(elected)
+		}
 
-			t := lib.ECSCluster{Region: configuration.Region, Cluster: configuration.Cluster, ECSClient: ecsClient, EC2Client: ec2Client}
-			r53 := lib.Route53{Domain: configuration.Domain, HostedZoneID: configuration.Zone}
+		isLeader := func() bool { return elector == nil || elector.IsLeader() }
 
-			e, err := t.GetTargets()
-			r53.Prune(e)
+		stop := make(chan struct{})
+		done := make(chan struct{})
 
-			if err != nil {
-				glog.Error(err)
+		go func() {
+			defer close(done)
+
+			// leading turns true on the first successful reconcile as leader, which prunes what a previous leader left behind
+			leading := false
+
+			// a failed discovery can return too few targets, pruning with them could wipe the zone,
+			// so the prune waits for the first successful reconcile
+			if elector == nil {
+				if e, err := r.backend.GetTargets(ctx); err != nil {
+					glog.Errorf("skipping the startup prune: %v", err)
+				} else {
+					if _, err := r.sinks.Prune(ctx, e); err != nil {
+						glog.Error(err)
+					}
+
+					leading = true
+				}
 			}
 
 			var lastHash uint64
 
-			for range ticker.C {
-				b, err := t.GetTargets()
+			// lastTargets is what the sinks were last synced with, the records of tasks a partial discovery skips are carried over from it
+			var lastTargets lib.Targets
 
-				if err != nil {
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				case <-reload.reloaded:
+				}
+
+				if next := reload.get(); next != r {
+					if next.config.Interval != r.config.Interval {
+						ticker.Reset(time.Second * time.Duration(next.config.Interval))
+					}
+
+					health.SetInterval(time.Second*time.Duration(next.config.Interval), int(next.config.ReadyIntervals))
+
+					r = next
+					// sync everything with the new configuration, and prune with the next complete discovery what it no
+					// longer names: groups the filters exclude, clusters removed and names changed by the template
+					lastHash = 0
+					lastTargets = nil
+					leading = false
+				}
+
+				if !isLeader() {
+					if leading {
+						glog.Info("lost the lease, following")
+					}
+
+					leading = false
+					// the leader may have changed the records since
+					lastTargets = nil
+
+					// followers keep discovering so they are warm and ready to take over
+					b, err := r.backend.GetTargets(ctx)
+
+					if err != nil {
+						glog.Error(err)
+					} else {
+						lib.RecordTargets(b)
+					}
+
+					health.Reconciled(err)
+					continue
+				}
+
+				start := time.Now()
+
+				b, err := r.backend.GetTargets(ctx)
+
+				switch {
+				case err == nil:
+				case errors.Is(err, lib.ErrPartialDiscovery):
+					// sinks replace the records of every group they sync, so the groups of skipped tasks keep their last
+					// synced targets, and pruning waits for a complete discovery
+					carried, ok := lib.CarryOver(b, lastTargets, err)
+
+					if !ok {
+						glog.Warningf("skipping the sync, the records of the tasks a partial discovery skipped can't be kept: %v", err)
+						lib.RecordReconcile(start, err)
+						health.Reconciled(err)
+						continue
+					}
+
+					glog.Warningf("syncing a partial discovery, leaving the records of the groups of skipped tasks alone: %v", err)
+					b = carried
+				case errors.Is(err, lib.ErrThrottled):
+					glog.Warningf("throttled by aws, retrying next interval: %v", err)
+					lib.RecordReconcile(start, err)
+					health.Reconciled(err)
+					continue
+				default:
 					glog.Error(err)
+					lib.RecordReconcile(start, err)
+					health.Reconciled(err)
 					continue
 				}
 
+				lib.RecordTargets(b)
+
+				if !leading && err == nil {
+					glog.Info("pruning and syncing every record")
+
+					if _, err := r.sinks.Prune(ctx, b); err != nil {
+						glog.Error(err)
+					}
+
+					leading = true
+					lastHash = 0
+				}
+
+				// a partial discovery is synced but doesn't count as a successful reconcile
+				discoveryErr := err
+
 				h, err := hashstructure.Hash(b, nil)
 
 				if err != nil {
@@ -79,33 +242,84 @@ var daemonCmd = &cobra.Command{
 
 				if h == lastHash {
 					glog.Info("targets haven't changed, hash is the same, continuing")
+					lib.RecordReconcile(start, discoveryErr)
+					health.Reconciled(discoveryErr)
 					continue
 				}
 
-				i, err := r53.Sync(b)
+				i, syncErr := r.sinks.Sync(ctx, b)
+				lastTargets = b
+				err = discoveryErr
 
-				if err != nil {
-					glog.Error(err)
+				if syncErr != nil {
+					glog.Error(syncErr)
+					err = syncErr
 				}
 
+				lib.RecordReconcile(start, err)
+				health.Reconciled(err)
+
 				glog.Infof("Records updated %d", i)
 				glog.V(1).Infof("lastHash %d, new hash %d", lastHash, h)
-				lastHash = h
+
+				// the sinks that failed are synced again next interval even when the targets haven't changed
+				if syncErr != nil {
+					lastHash = 0
+				} else {
+					lastHash = h
+				}
 
 			}
-		}(configuration)
+		}()
 
 		sC := make(chan os.Signal, 1)
-		signal.Notify(sC, os.Interrupt, os.Kill)
+		signal.Notify(sC, os.Interrupt, syscall.SIGTERM)
 
 		glog.Info("running...")
 
-		<-sC
+		sig := <-sC
+
+		// let the in-flight reconcile finish its change batch, then cancel it once the deadline passes
+		timeout := time.Second * time.Duration(reload.get().config.ShutdownTimeout)
+		glog.Infof("received %s, waiting up to %s for the reconcile to finish", sig, timeout)
+		close(stop)
+
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			glog.Warningf("reconcile still running after %s, cancelling it", timeout)
+			cancel()
+			<-done
+		}
+
+		if r := reload.get(); r.config.RemoveOnExit && isLeader() {
+			removeOnExit(r, timeout)
+		}
+
+		resign()
+		<-elected
 
 		glog.Info("exiting")
 	},
 }
 
+// removeOnExit removes the records of this instance once reconciling has stopped, so nothing re-adds them
+func removeOnExit(r *reconciler, timeout time.Duration) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	glog.Infof("removing the records of instance %s", r.config.Instance)
+
+	i, err := r.sinks.RemoveAllManagedRecords(ctx)
+
+	if err != nil {
+		glog.Error(err)
+	}
+
+	glog.Infof("Removed %d records", i)
+}
+
 func init() {
 	RootCmd.AddCommand(daemonCmd)
 }
@@ -0,0 +1,56 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/michaeld/ecs-dns/lib"
+)
+
+// newElector creates the elector of the daemon, nil when leader election is disabled
+func newElector(c *lib.Config, health *lib.Health) (*lib.Elector, error) {
+
+	if c.LeaseTable == "" {
+		return nil, nil
+	}
+
+	s, err := session.NewSession(&aws.Config{Region: aws.String(c.Region)})
+
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := os.Hostname()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &lib.Elector{
+		Lease: &lib.DynamoDBLease{
+			Client: dynamodb.New(health.Instrument(lib.InstrumentSession(s))),
+			Table:  c.LeaseTable,
+			Name:   c.LeaseName,
+		},
+		Holder: fmt.Sprintf("%s-%d", host, os.Getpid()),
+		TTL:    time.Second * time.Duration(c.LeaseTTL),
+	}, nil
+}
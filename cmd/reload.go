@@ -0,0 +1,214 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+	"github.com/michaeld/ecs-dns/lib"
+	"github.com/spf13/viper"
+)
+
+// reconciler holds what the reconcile loop runs with, it is replaced whole when the configuration is reloaded
+type reconciler struct {
+	config  *lib.Config
+	backend lib.Backend
+	sinks   *lib.Sinks
+}
+
+// newReconciler resolves the hosted zone and creates the backend and sinks of c
+func newReconciler(ctx context.Context, c *lib.Config, health *lib.Health) (*reconciler, error) {
+
+	if err := resolveZone(ctx, c); err != nil {
+		return nil, err
+	}
+
+	sinks, err := lib.NewSinks(c.Sinks, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &reconciler{config: c, backend: newBackend(c, health.Instrument), sinks: sinks}, nil
+}
+
+// reloader swaps the reconciler of the daemon when the config file changes or on SIGHUP.
+// It never writes to the sinks, the reconcile loop syncs the new configuration when this replica leads
+type reloader struct {
+	// ctx bounds the hosted zone lookup of a new configuration
+	ctx     context.Context
+	current atomic.Value
+	health  *lib.Health
+	// elector is nil without leader election
+	elector *lib.Elector
+	// reloaded wakes the reconcile loop to sync a new configuration right away
+	reloaded chan struct{}
+	// mu serializes reloads and every viper read and write, as viper isn't safe for concurrent use
+	mu sync.Mutex
+}
+
+func newReloader(ctx context.Context, r *reconciler, health *lib.Health, elector *lib.Elector) *reloader {
+	reload := &reloader{ctx: ctx, health: health, elector: elector, reloaded: make(chan struct{}, 1)}
+	reload.current.Store(r)
+
+	return reload
+}
+
+func (r *reloader) get() *reconciler {
+	return r.current.Load().(*reconciler)
+}
+
+// watch reloads on config file changes and SIGHUP until the process exits
+func (r *reloader) watch() {
+
+	if path := viper.ConfigFileUsed(); path != "" {
+		r.watchFile(path)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			glog.Info("SIGHUP received, reloading the configuration")
+			r.reload()
+		}
+	}()
+}
+
+// watchFile reloads when the config file changes. Unlike viper.WatchConfig the file is re-read under mu,
+// so it never races the reads of a reload. The directory is watched as editors and Kubernetes replace the file rather than write it
+func (r *reloader) watchFile(path string) {
+
+	w, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		glog.Errorf("not watching %s: %v", path, err)
+		return
+	}
+
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		glog.Errorf("not watching %s: %v", path, err)
+		w.Close()
+		return
+	}
+
+	real, _ := filepath.EvalSymlinks(path)
+
+	go func() {
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+
+				current, _ := filepath.EvalSymlinks(path)
+
+				written := filepath.Clean(e.Name) == filepath.Clean(path) && e.Op&(fsnotify.Write|fsnotify.Create) != 0
+
+				// a symlinked file, such as a Kubernetes ConfigMap, changes when the link moves to a new target
+				if !written && (current == "" || current == real) {
+					continue
+				}
+
+				real = current
+
+				glog.Infof("config file %s changed", path)
+				r.reload()
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+
+				glog.Errorf("watching %s: %v", path, err)
+			}
+		}
+	}()
+}
+
+// reload re-reads the config file, validates the configuration and swaps it in, keeping the current one on any error
+func (r *reloader) reload() {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			glog.Errorf("keeping the current configuration: %v", err)
+			return
+		}
+	}
+
+	c, err := loadConfig()
+
+	if err == nil {
+		err = c.Validate()
+	}
+
+	var next *reconciler
+
+	if err == nil {
+		next, err = newReconciler(r.ctx, c, r.health)
+	}
+
+	if err != nil {
+		glog.Errorf("keeping the current configuration: %v", err)
+		return
+	}
+
+	old := r.get()
+	changes := c.Diff(old.config)
+
+	if len(changes) == 0 {
+		glog.Info("configuration unchanged")
+		return
+	}
+
+	if c.ListenAddress != old.config.ListenAddress {
+		glog.Warningf("listen-address changes take effect on restart, still serving on %s", old.config.ListenAddress)
+	}
+
+	if c.LeaseTable != old.config.LeaseTable || c.LeaseName != old.config.LeaseName || c.LeaseTTL != old.config.LeaseTTL {
+		glog.Warning("leader-election changes take effect on restart")
+	}
+
+	if c.Instance != old.config.Instance {
+		glog.Warningf("instance changed from %q to %q, the records of the old instance are no longer managed and have to be removed with remove --instance", old.config.Instance, c.Instance)
+	}
+
+	r.current.Store(next)
+
+	glog.Infof("configuration reloaded: %s", strings.Join(changes, "; "))
+
+	if r.elector != nil && !r.elector.IsLeader() {
+		glog.Info("following, the leader syncs its own configuration")
+		return
+	}
+
+	select {
+	case r.reloaded <- struct{}{}:
+	default:
+	}
+}
@@ -15,6 +15,9 @@
 package cmd
 
 import (
+	"context"
+
+	"github.com/golang/glog"
 	"github.com/michaeld/ecs-dns/lib"
 	"github.com/spf13/cobra"
 )
@@ -22,12 +25,24 @@ import (
 // removeCmd represents the remove command
 var removeCmd = &cobra.Command{
 	Use:   "remove",
-	Short: "remove all managed SRV records",
+	Short: "remove all managed records from the enabled sinks",
 	Run: func(cmd *cobra.Command, args []string) {
 
-		r := lib.Route53{Domain: configuration.Domain, HostedZoneID: configuration.Zone}
+		validateConfig(configuration)
+
+		ctx := context.Background()
+
+		if err := resolveZone(ctx, configuration); err != nil {
+			glog.Fatal(err)
+		}
+
+		sinks, err := lib.NewSinks(configuration.Sinks, configuration)
+
+		if err != nil {
+			glog.Fatal(err)
+		}
 
-		r.RemoveAllManagedRecords()
+		sinks.RemoveAllManagedRecords(ctx)
 	},
 }
 
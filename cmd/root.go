@@ -16,7 +16,9 @@ package cmd
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/michaeld/ecs-dns/lib"
 
@@ -42,46 +44,215 @@ func Execute() {
 
 var configuration *lib.Config
 
+// envPrefix prefixes the environment variables overriding settings, ECS_DNS_SINKS_ROUTE53_ZONE sets sinks.route53.zone
+const envPrefix = "ECS_DNS"
+
+// settings maps the keys of the config file schema to the flags overriding them
+var settings = []struct{ key, flag string }{
+	{"aws.region", "region"},
+	{"cluster", "cluster"},
+	{"domain", "domain"},
+	{"interval", "interval"},
+	{"ready-intervals", "ready-intervals"},
+	{"shutdown-timeout", "shutdown-timeout"},
+	{"instance", "instance"},
+	{"remove-on-exit", "remove-on-exit"},
+	{"prune.max-delete-percent", "max-delete-percent"},
+	{"prune.min-delete-count", "min-delete-count"},
+	{"leader-election.table", "lease-table"},
+	{"leader-election.name", "lease-name"},
+	{"leader-election.ttl", "lease-ttl"},
+	{"listen-address", "listen-address"},
+	{"naming.template", "name-template"},
+	{"filters.include", "include"},
+	{"filters.exclude", "exclude"},
+	{"filters.service-tags", "service-tag"},
+	{"sinks.enabled", "sink"},
+	{"sinks.route53.zone", "zone"},
+	{"sinks.route53.private-zone", "private-zone"},
+	{"sinks.route53.create-zone", "create-zone"},
+	{"sinks.file_sd.path", "file-sd-path"},
+	{"sinks.file_sd.format", "file-sd-format"},
+	{"sinks.cloudmap.namespace", "cloudmap-namespace"},
+	{"sinks.consul.address", "consul-address"},
+	{"sinks.consul.token", "consul-token"},
+	{"sinks.rfc2136.server", "rfc2136-server"},
+	{"sinks.rfc2136.tsig-key", "rfc2136-tsig-key"},
+	{"sinks.rfc2136.tsig-secret", "rfc2136-tsig-secret"},
+	{"sinks.rfc2136.tsig-algorithm", "rfc2136-tsig-algorithm"},
+	{"dns.address", "dns-address"},
+	{"dns.ttl", "dns-ttl"},
+}
+
 func init() {
 
-	if cfgFile != "" { // enable ability to specify config file via flag
-		viper.SetConfigFile(cfgFile)
-	}
+	cobra.OnInitialize(initConfig)
+
+	f := RootCmd.PersistentFlags()
 
-	viper.SetConfigName("config") // name of config file (without extension)
-	viper.AddConfigPath(".")      // adding current directory
-	viper.AutomaticEnv()          // read in environment variables that match
+	f.StringVar(&cfgFile, "config", "", "config file (default is config.yaml in . or /etc/ecs-dns)")
 
-	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
+	f.String("domain", "", "domain name")
+	f.String("zone", "", "hosted zone id, looked up from the domain when empty")
+	f.Bool("private-zone", false, "only consider private hosted zones when looking up the zone of the domain")
+	f.Bool("create-zone", false, "create the private hosted zone of the domain when --zone isn't given and associate it with the cluster vpcs")
+	f.Int64("interval", 10, "poll interval in seconds")
+	f.String("region", "us-east-1", "aws region of the clusters and sinks")
+	f.String("cluster", "", "ecs cluster name, the clusters list of the config file discovers several")
+	f.StringSlice("include", nil, "only manage groups matching these globs, <group>/<container> when the glob has a slash")
+	f.StringSlice("exclude", nil, "don't manage groups matching these globs, <group>/<container> when the glob has a slash")
+	f.StringSlice("service-tag", nil, "only manage the tasks of services tagged with every key=value")
+	f.String("name-template", lib.DefaultNameTemplate, "go text/template used to name records")
+	f.String("listen-address", "localhost:8080", "address the daemon serves /metrics, /healthz, /readyz and /http_sd on, empty to disable")
+	f.StringSlice("sink", []string{"route53"}, "sinks to write targets to: "+strings.Join(lib.SinkNames(), ", "))
+	f.String("file-sd-path", "", "write targets to this prometheus file_sd_configs file")
+	f.String("file-sd-format", "", "file_sd format, json or yaml (default from the file extension)")
+	f.String("cloudmap-namespace", "", "cloud map namespace id for the cloudmap sink")
+	f.String("consul-address", "http://127.0.0.1:8500", "consul agent address for the consul sink")
+	f.String("consul-token", "", "consul acl token for the consul sink")
+	f.String("rfc2136-server", "", "authoritative server host:port for the rfc2136 sink")
+	f.String("rfc2136-tsig-key", "", "tsig key name for the rfc2136 sink")
+	f.String("rfc2136-tsig-secret", "", "base64 tsig secret for the rfc2136 sink")
+	f.String("rfc2136-tsig-algorithm", "hmac-sha256", "tsig algorithm for the rfc2136 sink")
+	f.String("dns-address", ":53", "address serve-dns answers queries on over udp and tcp")
+	f.Int64("dns-ttl", 0, "ttl of the records answered by serve-dns")
+	f.Int64("ready-intervals", 3, "intervals without a successful reconcile before /readyz fails")
+	f.String("instance", "", "name scoping the records this ecs-dns manages, so several instances can share a zone")
+	f.Bool("remove-on-exit", false, "remove the records of this instance when the daemon stops, requires --instance")
+	f.Float64("max-delete-percent", 50, "refuse prunes deleting more than this percentage of the managed records, 0 to disable")
+	f.Int("min-delete-count", 5, "allow prunes deleting at most this many records whatever --max-delete-percent")
+	f.String("lease-table", "", "dynamodb table holding the leader lease, so only one of several daemon replicas reconciles")
+	f.String("lease-name", "ecs-dns", "name of the leader lease, replicas of the same daemon share it")
+	f.Int64("lease-ttl", 15, "seconds before followers take over the lease of a leader that stopped renewing it")
+	f.Int64("shutdown-timeout", 30, "seconds the daemon waits for an in-flight reconcile on SIGTERM before cancelling it")
 
-	pflag.String("domain", "", "domain name")
-	pflag.String("zone", "", "hosted zone id")
-	pflag.String("interval", "10", "poll interval in seconds")
-	pflag.String("region", "us-east-1", "ecs cluster region")
-	pflag.String("cluster", "", "ecs cluster name")
+	for _, s := range settings {
+		viper.BindPFlag(s.key, f.Lookup(s.flag))
+	}
 
 	//set logging to stderr by default
 	flag.Set("logtostderr", "true")
 
-	viper.SetDefault("interval", 10)
-
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
-	pflag.Parse()
 	flag.CommandLine.Parse([]string{})
-	viper.BindPFlags(pflag.CommandLine)
+}
+
+// initConfig reads the config file and environment once cobra has parsed the flags
+func initConfig() {
+
+	if cfgFile != "" { // enable ability to specify config file via flag
+		viper.SetConfigFile(cfgFile)
+	} else {
+		viper.SetConfigName("config") // name of config file (without extension)
+		viper.AddConfigPath(".")      // adding current directory
+		viper.AddConfigPath("/etc/ecs-dns")
+	}
+
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		glog.Info("Using config file:", viper.ConfigFileUsed())
+	} else if cfgFile != "" {
+		glog.Fatal(err)
+	}
+
+	// flat keys named after the flags predate the nested schema
+	for _, s := range settings {
+		if s.key != s.flag && viper.InConfig(s.flag) && !viper.InConfig(s.key) {
+			glog.Warningf("config key %s is deprecated, use %s", s.flag, s.key)
+			viper.SetDefault(s.key, viper.Get(s.flag))
+		}
 	}
 
 	glog.V(1).Info(viper.AllSettings())
 
-	configuration = &lib.Config{
-		Region:   viper.GetString("region"),
-		Domain:   viper.GetString("domain"),
-		Zone:     viper.GetString("zone"),
-		Cluster:  viper.GetString("cluster"),
-		Interval: viper.GetInt64("interval"),
+	c, err := loadConfig()
+
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	configuration = c
+}
+
+// loadConfig builds the configuration from the flags, environment, config file and defaults, in that order of precedence
+func loadConfig() (*lib.Config, error) {
+
+	c := &lib.Config{
+		Region:              viper.GetString("aws.region"),
+		Domain:              viper.GetString("domain"),
+		Zone:                viper.GetString("sinks.route53.zone"),
+		PrivateZone:         viper.GetBool("sinks.route53.private-zone"),
+		CreateZone:          viper.GetBool("sinks.route53.create-zone"),
+		Interval:            viper.GetInt64("interval"),
+		ReadyIntervals:      viper.GetInt64("ready-intervals"),
+		ShutdownTimeout:     viper.GetInt64("shutdown-timeout"),
+		Instance:            viper.GetString("instance"),
+		RemoveOnExit:        viper.GetBool("remove-on-exit"),
+		MaxDeletePercent:    viper.GetFloat64("prune.max-delete-percent"),
+		MinDeleteCount:      viper.GetInt("prune.min-delete-count"),
+		LeaseTable:          viper.GetString("leader-election.table"),
+		LeaseName:           viper.GetString("leader-election.name"),
+		LeaseTTL:            viper.GetInt64("leader-election.ttl"),
+		FileSDPath:          viper.GetString("sinks.file_sd.path"),
+		FileSDFormat:        viper.GetString("sinks.file_sd.format"),
+		Sinks:               stringSlice("sinks.enabled"),
+		CloudMapNamespaceID: viper.GetString("sinks.cloudmap.namespace"),
+		ConsulAddress:       viper.GetString("sinks.consul.address"),
+		ConsulToken:         viper.GetString("sinks.consul.token"),
+		DNSAddress:          viper.GetString("dns.address"),
+		DNSTTL:              viper.GetInt64("dns.ttl"),
+		Filter: lib.Filter{
+			Include:     stringSlice("filters.include"),
+			Exclude:     stringSlice("filters.exclude"),
+			ServiceTags: stringSlice("filters.service-tags"),
+		},
+
+		RFC2136Server:        viper.GetString("sinks.rfc2136.server"),
+		RFC2136TSIGKeyName:   viper.GetString("sinks.rfc2136.tsig-key"),
+		RFC2136TSIGSecret:    viper.GetString("sinks.rfc2136.tsig-secret"),
+		RFC2136TSIGAlgorithm: viper.GetString("sinks.rfc2136.tsig-algorithm"),
+		NameTemplate:         viper.GetString("naming.template"),
+		ListenAddress:        viper.GetString("listen-address"),
+	}
+
+	// --cluster replaces the clusters list of the config file
+	if name := viper.GetString("cluster"); name != "" {
+		c.Clusters = []lib.ClusterConfig{{Name: name}}
+	} else if err := viper.UnmarshalKey("clusters", &c.Clusters); err != nil {
+		return nil, fmt.Errorf("clusters: %v", err)
+	}
+
+	for i := range c.Clusters {
+		if c.Clusters[i].Region == "" {
+			c.Clusters[i].Region = c.Region
+		}
+	}
+
+	return c, nil
+}
+
+// stringSlice reads a list setting, splitting the comma separated values environment variables hold
+func stringSlice(key string) []string {
+	s := []string{}
+
+	for _, v := range viper.GetStringSlice(key) {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				s = append(s, p)
+			}
+		}
+	}
+
+	return s
+}
+
+// validateConfig exits listing every problem with the configuration
+func validateConfig(c *lib.Config) {
+	if err := c.Validate(); err != nil {
+		glog.Fatal(err)
 	}
 }
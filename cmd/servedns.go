@@ -0,0 +1,121 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/michaeld/ecs-dns/lib"
+	"github.com/spf13/cobra"
+)
+
+// serveDNSCmd represents the serve-dns command
+var serveDNSCmd = &cobra.Command{
+	Use:   "serve-dns",
+	Short: "answer SRV, A and TXT queries for the domain from discovered targets",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		validateConfig(configuration)
+
+		if configuration.Domain == "" {
+			glog.Fatal("domain is required by serve-dns")
+		}
+
+		n, err := lib.NewNaming(configuration.NameTemplate, configuration.Domain)
+
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		server := &lib.DNSServer{Domain: configuration.Domain, TTL: uint32(configuration.DNSTTL), Naming: n, Owner: lib.Owner(configuration.Instance)}
+
+		t := newBackend(configuration)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		b, err := t.GetTargets(ctx)
+
+		// the groups of the tasks a partial discovery skipped keep the names they were served with, none at first
+		served, ok := lib.CarryOver(b, lib.Targets{}, err)
+
+		if err != nil && !errors.Is(err, lib.ErrPartialDiscovery) {
+			// a throttled or failed discovery shouldn't stop the server, it answers with no names until the next interval
+			glog.Errorf("serving no names until the next discovery: %v", err)
+		}
+
+		if !ok {
+			served = lib.Targets{}
+		}
+
+		server.Sync(ctx, served)
+
+		go func() {
+			glog.Infof("answering queries for %s on %s", configuration.Domain, configuration.DNSAddress)
+			glog.Fatal(server.ListenAndServe(configuration.DNSAddress))
+		}()
+
+		go func() {
+
+			ticker := time.NewTicker(time.Second * time.Duration(configuration.Interval))
+			defer ticker.Stop()
+
+			for range ticker.C {
+				b, err := t.GetTargets(ctx)
+
+				if err != nil && !errors.Is(err, lib.ErrPartialDiscovery) {
+					glog.Error(err)
+					continue
+				}
+
+				b, ok := lib.CarryOver(b, served, err)
+
+				if !ok {
+					glog.Errorf("keeping the served names, the tasks a partial discovery skipped can't be told apart: %v", err)
+					continue
+				}
+
+				served = b
+
+				lib.RecordTargets(b)
+
+				i, err := server.Sync(ctx, b)
+
+				if err != nil {
+					glog.Error(err)
+				}
+
+				glog.V(1).Infof("Serving %d names", i)
+			}
+		}()
+
+		sC := make(chan os.Signal, 1)
+		signal.Notify(sC, os.Interrupt, syscall.SIGTERM)
+
+		<-sC
+
+		glog.Info("exiting")
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(serveDNSCmd)
+}
@@ -15,10 +15,9 @@
 package cmd
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ecs"
+	"context"
+	"errors"
+
 	"github.com/golang/glog"
 	"github.com/michaeld/ecs-dns/lib"
 	"github.com/spf13/cobra"
@@ -30,33 +29,49 @@ var syncCmd = &cobra.Command{
 	Short: "prune dead backends, readd active",
 	Run: func(cmd *cobra.Command, args []string) {
 
-		s, err := session.NewSession(&aws.Config{Region: aws.String(configuration.Region)})
+		validateConfig(configuration)
 
-		if err != nil {
-			glog.Fatal(err)
-		}
+		configuration.Force = force
 
-		ecsClient := ecs.New(s)
-		ec2Client := ec2.New(s)
+		t := newBackend(configuration)
 
-		t := lib.ECSCluster{Region: configuration.Region, Cluster: configuration.Cluster, ECSClient: ecsClient, EC2Client: ec2Client}
-		r53 := lib.Route53{Domain: configuration.Domain, HostedZoneID: configuration.Zone}
+		ctx := context.Background()
+
+		if err := resolveZone(ctx, configuration); err != nil {
+			glog.Fatal(err)
+		}
 
-		b, err := t.GetTargets()
+		sinks, err := lib.NewSinks(configuration.Sinks, configuration)
 
 		if err != nil {
 			glog.Fatal(err)
 		}
 
-		p, err := r53.Prune(b)
+		b, err := t.GetTargets(ctx)
 
-		glog.V(1).Info("Pruning", p)
+		// a partial discovery is never pruned, and only synced when it skipped no task, as the records of
+		// the groups of skipped tasks would be replaced without them
+		if errors.Is(err, lib.ErrPartialDiscovery) {
+			var ok bool
 
-		if err != nil {
-			glog.Error(err)
+			if b, ok = lib.CarryOver(b, nil, err); !ok {
+				glog.Fatalf("not syncing a partial discovery, the records of the tasks it skipped would be dropped: %v", err)
+			}
+
+			glog.Warningf("skipping the prune: %v", err)
+		} else if err != nil {
+			glog.Fatal(err)
+		} else {
+			p, err := sinks.Prune(ctx, b)
+
+			glog.V(1).Info("Pruning", p)
+
+			if err != nil {
+				glog.Error(err)
+			}
 		}
 
-		i, err := r53.Sync(b)
+		i, err := sinks.Sync(ctx, b)
 
 		if err != nil {
 			glog.Error(err)
@@ -66,6 +81,9 @@ var syncCmd = &cobra.Command{
 	},
 }
 
+var force bool
+
 func init() {
+	syncCmd.Flags().BoolVar(&force, "force", false, "prune even when it deletes more than --max-delete-percent of the managed records")
 	RootCmd.AddCommand(syncCmd)
 }
@@ -0,0 +1,86 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/golang/glog"
+	"github.com/michaeld/ecs-dns/lib"
+)
+
+// resolveZone looks up the hosted zone id of the domain when --zone isn't given, creating a private zone with --create-zone,
+// and refuses to run when the given zone doesn't host the domain
+func resolveZone(ctx context.Context, c *lib.Config) error {
+
+	if !usesSink(c, "route53") {
+		return nil
+	}
+
+	s, err := session.NewSession(&aws.Config{Region: aws.String(c.Region)})
+
+	if err != nil {
+		return err
+	}
+
+	lib.InstrumentSession(s)
+
+	zones, err := hostedZones(ctx, s, c)
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c.Zone != "":
+		err = zones.Verify(ctx, c.Zone, c.Domain)
+	case c.CreateZone:
+		c.Zone, err = zones.EnsurePrivate(ctx, c.Domain)
+	default:
+		c.Zone, err = zones.Find(ctx, c.Domain, c.PrivateZone)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Using hosted zone %s for %s", c.Zone, c.Domain)
+
+	return nil
+}
+
+// hostedZones returns the hosted zone lookup scoped to the vpcs the clusters run in
+func hostedZones(ctx context.Context, s *session.Session, c *lib.Config) (*lib.HostedZones, error) {
+	vpcs, err := newClusters(c).VPCs(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &lib.HostedZones{Client: route53.New(s), VPCs: vpcs}, nil
+}
+
+func usesSink(c *lib.Config, name string) bool {
+	for _, s := range c.Sinks {
+		if s == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/michaeld/ecs-dns/lib"
+)
+
+//consulAddress is a local dev agent, started with `consul agent -dev`
+func consulAddress(t *testing.T) string {
+	addr := os.Getenv("CONSUL_HTTP_ADDR")
+
+	if addr == "" {
+		addr = "http://127.0.0.1:8500"
+	}
+
+	if _, err := http.Get(addr + "/v1/agent/self"); err != nil {
+		t.Skipf("consul agent not reachable at %s: %v", addr, err)
+	}
+
+	return addr
+}
+
+var consulTargets = lib.Targets{
+	"group1": {
+		"container1": []*lib.Target{
+			{Name: "container1", Group: "group1", Cluster: "sandbox1", IPAddress: "10.0.0.1", Port: 1234},
+			{Name: "container1", Group: "group1", Cluster: "sandbox1", IPAddress: "10.0.0.2", Port: 1234},
+		},
+	},
+}
+
+func TestConsulSync(t *testing.T) {
+
+	c := &lib.Consul{Address: consulAddress(t)}
+
+	defer c.RemoveAllManagedRecords(context.Background())
+
+	n, err := c.Sync(context.Background(), consulTargets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = c.Sync(context.Background(), consulTargets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "unchanged services shouldn't be registered again")
+
+	n, err = c.Prune(context.Background(), lib.Targets{"group1": {"container1": consulTargets["group1"]["container1"][:1]}})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestConsulRemoveAllManagedRecords(t *testing.T) {
+
+	c := &lib.Consul{Address: consulAddress(t)}
+
+	c.Sync(context.Background(), consulTargets)
+
+	n, err := c.RemoveAllManagedRecords(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -34,14 +35,14 @@ func TestSync(t *testing.T) {
 
 	var ecs lib.Backend = &lib.ECSCluster{Region: region, Cluster: cluster, ECSClient: ecsClient, EC2Client: ec2Client}
 
-	b, err := ecs.GetTargets()
+	b, err := ecs.GetTargets(context.Background())
 
 	if err != nil {
 		t.Error(err)
 		t.Fail()
 	}
 
-	changes, err := r.Sync(b)
+	changes, err := r.Sync(context.Background(), b)
 
 	if err != nil {
 		t.Error(err)
@@ -53,7 +54,7 @@ func TestSync(t *testing.T) {
 
 func TestRemoveAllManagedRecords(t *testing.T) {
 
-	n, err := r.RemoveAllManagedRecords()
+	n, err := r.RemoveAllManagedRecords(context.Background())
 
 	if err != nil {
 		t.Error(err)
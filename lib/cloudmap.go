@@ -0,0 +1,446 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/golang/glog"
+)
+
+//cloudMapDescription marks the Cloud Map services created by ecs-dns
+const cloudMapDescription = "managed by ecs-dns"
+
+//cloudMapOwner is the instance attribute holding the owner identifier
+const cloudMapOwner = "ECS_DNS_OWNER"
+
+func init() {
+	RegisterSink("cloudmap", func(c *Config) (DNS, error) {
+		if c.CloudMapNamespaceID == "" {
+			return nil, fmt.Errorf("cloudmap-namespace is required")
+		}
+
+		s, err := session.NewSession(&aws.Config{Region: aws.String(c.Region)})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &CloudMap{NamespaceID: c.CloudMapNamespaceID, Client: servicediscovery.New(InstrumentSession(s)), Owner: Owner(c.Instance), Guard: c.pruneGuard()}, nil
+	})
+}
+
+//ServiceDiscoveryApi contains the functions necessary to interact with AWS Cloud Map
+type ServiceDiscoveryApi interface {
+	GetNamespaceWithContext(aws.Context, *servicediscovery.GetNamespaceInput, ...request.Option) (*servicediscovery.GetNamespaceOutput, error)
+	ListServicesPagesWithContext(aws.Context, *servicediscovery.ListServicesInput, func(*servicediscovery.ListServicesOutput, bool) bool, ...request.Option) error
+	CreateServiceWithContext(aws.Context, *servicediscovery.CreateServiceInput, ...request.Option) (*servicediscovery.CreateServiceOutput, error)
+	ListInstancesPagesWithContext(aws.Context, *servicediscovery.ListInstancesInput, func(*servicediscovery.ListInstancesOutput, bool) bool, ...request.Option) error
+	RegisterInstanceWithContext(aws.Context, *servicediscovery.RegisterInstanceInput, ...request.Option) (*servicediscovery.RegisterInstanceOutput, error)
+	DeregisterInstanceWithContext(aws.Context, *servicediscovery.DeregisterInstanceInput, ...request.Option) (*servicediscovery.DeregisterInstanceOutput, error)
+}
+
+//CloudMap registers targets as instances of AWS Cloud Map services, one service per group and container
+type CloudMap struct {
+	NamespaceID   string
+	Client        ServiceDiscoveryApi
+	Owner         Owner
+	Guard         PruneGuard
+	namespaceType string
+}
+
+//Sync creates missing services, registers new or changed instances and deregisters instances of the synced services no longer in targets
+func (c *CloudMap) Sync(ctx context.Context, targets Targets) (int, error) {
+
+	services, err := c.managedServices(ctx)
+
+	if err != nil {
+		glog.Error(err)
+		return 0, err
+	}
+
+	names, nameErr := cloudMapServiceNames(targets)
+
+	if nameErr != nil {
+		glog.Error(nameErr)
+	}
+
+	changes := 0
+	lastErr := nameErr
+
+	for group, service := range names {
+		for container, name := range service {
+
+			ts := targets[group][container]
+			id, found := services[name]
+
+			if !found {
+				if id, err = c.createService(ctx, name); err != nil {
+					glog.Errorf("Creating Cloud Map service %s: %v", name, err)
+					lastErr = err
+					continue
+				}
+			}
+
+			n, err := c.syncInstances(ctx, id, c.Owner.id(group, container), ts)
+
+			changes += n
+
+			if err != nil {
+				glog.Errorf("Syncing Cloud Map service %s: %v", name, err)
+				lastErr = err
+			}
+		}
+	}
+
+	return changes, lastErr
+}
+
+//Prune deregisters managed instances no longer registered with the backend, and those left in a service of another name
+func (c *CloudMap) Prune(ctx context.Context, targets Targets) (int, error) {
+
+	// services that failed to be named are reported by Sync, their instances are kept
+	names, _ := cloudMapServiceNames(targets)
+
+	renamed := func(service, group, container, id string) bool {
+		name, found := names[group][container]
+
+		return found && !strings.EqualFold(name, service)
+	}
+
+	return c.deregister(ctx, c.Guard, renamed, func(service, group, container, id string) bool {
+		for _, t := range targets[group][container] {
+			if cloudMapInstanceID(t) == id {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+//RemoveAllManagedRecords deregisters every managed instance
+func (c *CloudMap) RemoveAllManagedRecords(ctx context.Context) (int, error) {
+	return c.deregister(ctx, PruneGuard{}, nil, func(service, group, container, id string) bool { return true })
+}
+
+//syncInstances registers the targets of a service and deregisters its other instances owned by owner
+func (c *CloudMap) syncInstances(ctx context.Context, serviceID, owner string, targets []*Target) (int, error) {
+
+	existing, err := c.instances(ctx, serviceID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	changes := 0
+	desired := map[string]bool{}
+
+	for _, t := range targets {
+		id := cloudMapInstanceID(t)
+		attrs := cloudMapAttributes(t, owner)
+
+		desired[id] = true
+
+		if i, found := existing[id]; found && equalAttributes(i.Attributes, attrs) {
+			continue
+		}
+
+		glog.Infof("Registering Cloud Map instance %s in %s", id, serviceID)
+
+		_, err := c.Client.RegisterInstanceWithContext(ctx, &servicediscovery.RegisterInstanceInput{
+			ServiceId:  aws.String(serviceID),
+			InstanceId: aws.String(id),
+			Attributes: attrs,
+		})
+
+		if err != nil {
+			return changes, err
+		}
+
+		changes++
+	}
+
+	for id, i := range existing {
+		if desired[id] || aws.StringValue(i.Attributes[cloudMapOwner]) != owner {
+			continue
+		}
+
+		if err := c.deregisterInstance(ctx, serviceID, id); err != nil {
+			return changes, err
+		}
+
+		changes++
+	}
+
+	return changes, nil
+}
+
+//deregister removes the instances of managed services for which remove returns true, unless guard refuses, and those for
+//which renamed returns true. Renamed instances belong to targets that were discovered, so the guard doesn't hold them back
+func (c *CloudMap) deregister(ctx context.Context, guard PruneGuard, renamed, remove func(service, group, container, id string) bool) (int, error) {
+
+	services, err := c.managedServices(ctx)
+
+	if err != nil {
+		glog.Error(err)
+		return 0, err
+	}
+
+	managed := 0
+	removes, renames := map[string][]string{}, map[string][]string{}
+	var lastErr error
+
+	for service, serviceID := range services {
+
+		instances, err := c.instances(ctx, serviceID)
+
+		if err != nil {
+			glog.Error(err)
+			lastErr = err
+			continue
+		}
+
+		for id, i := range instances {
+			group, container, ok := c.Owner.parse(aws.StringValue(i.Attributes[cloudMapOwner]))
+
+			if !ok {
+				continue
+			}
+
+			managed++
+
+			if remove(service, group, container, id) {
+				removes[serviceID] = append(removes[serviceID], id)
+			} else if renamed != nil && renamed(service, group, container, id) {
+				renames[serviceID] = append(renames[serviceID], id)
+			}
+		}
+	}
+
+	deletes := 0
+
+	for _, ids := range removes {
+		deletes += len(ids)
+	}
+
+	if err := guard.Check(managed, deletes); err != nil {
+		glog.Error(err)
+		lastErr = err
+		removes = map[string][]string{}
+	}
+
+	for serviceID, ids := range renames {
+		removes[serviceID] = append(removes[serviceID], ids...)
+	}
+
+	changes := 0
+
+	for serviceID, ids := range removes {
+		for _, id := range ids {
+			if err := c.deregisterInstance(ctx, serviceID, id); err != nil {
+				glog.Error(err)
+				lastErr = err
+				continue
+			}
+
+			changes++
+		}
+	}
+
+	return changes, lastErr
+}
+
+func (c *CloudMap) deregisterInstance(ctx context.Context, serviceID, id string) error {
+	glog.Infof("Deregistering Cloud Map instance %s from %s", id, serviceID)
+
+	_, err := c.Client.DeregisterInstanceWithContext(ctx, &servicediscovery.DeregisterInstanceInput{
+		ServiceId:  aws.String(serviceID),
+		InstanceId: aws.String(id),
+	})
+
+	return err
+}
+
+//managedServices returns the ids of the services created by ecs-dns in the namespace keyed by name
+func (c *CloudMap) managedServices(ctx context.Context) (map[string]string, error) {
+	services := map[string]string{}
+
+	err := c.Client.ListServicesPagesWithContext(ctx, &servicediscovery.ListServicesInput{
+		Filters: []*servicediscovery.ServiceFilter{
+			&servicediscovery.ServiceFilter{
+				Name:      aws.String(servicediscovery.ServiceFilterNameNamespaceId),
+				Condition: aws.String(servicediscovery.FilterConditionEq),
+				Values:    []*string{aws.String(c.NamespaceID)},
+			},
+		},
+	}, func(o *servicediscovery.ListServicesOutput, lastPage bool) bool {
+
+		for _, s := range o.Services {
+			if aws.StringValue(s.Description) == cloudMapDescription {
+				services[*s.Name] = *s.Id
+			}
+		}
+
+		return !lastPage
+	})
+
+	return services, err
+}
+
+func (c *CloudMap) instances(ctx context.Context, serviceID string) (map[string]*servicediscovery.InstanceSummary, error) {
+	instances := map[string]*servicediscovery.InstanceSummary{}
+
+	err := c.Client.ListInstancesPagesWithContext(ctx, &servicediscovery.ListInstancesInput{ServiceId: aws.String(serviceID)},
+		func(o *servicediscovery.ListInstancesOutput, lastPage bool) bool {
+
+			for _, i := range o.Instances {
+				instances[*i.Id] = i
+			}
+
+			return !lastPage
+		})
+
+	return instances, err
+}
+
+//createService creates a service in the namespace, with SRV records when the namespace is a DNS namespace
+func (c *CloudMap) createService(ctx context.Context, name string) (string, error) {
+
+	if c.namespaceType == "" {
+		o, err := c.Client.GetNamespaceWithContext(ctx, &servicediscovery.GetNamespaceInput{Id: aws.String(c.NamespaceID)})
+
+		if err != nil {
+			return "", err
+		}
+
+		c.namespaceType = *o.Namespace.Type
+	}
+
+	input := &servicediscovery.CreateServiceInput{
+		Name:        aws.String(name),
+		NamespaceId: aws.String(c.NamespaceID),
+		Description: aws.String(cloudMapDescription),
+	}
+
+	if c.namespaceType != servicediscovery.NamespaceTypeHttp {
+		input.DnsConfig = &servicediscovery.DnsConfig{
+			RoutingPolicy: aws.String(servicediscovery.RoutingPolicyMultivalue),
+			DnsRecords: []*servicediscovery.DnsRecord{
+				// TTL=0 to avoid DNS caches
+				&servicediscovery.DnsRecord{Type: aws.String(servicediscovery.RecordTypeSrv), TTL: aws.Int64(0)},
+			},
+		}
+	}
+
+	glog.Infof("Creating Cloud Map service %s", name)
+
+	o, err := c.Client.CreateServiceWithContext(ctx, input)
+
+	if err != nil {
+		return "", err
+	}
+
+	return *o.Service.Id, nil
+}
+
+func cloudMapServiceName(group, container string) string {
+	return fmt.Sprintf("%s.%s", label(container), label(group))
+}
+
+//cloudMapServiceNames names the service of every group and container. Names claimed by more than one group and container
+//are left out and reported in a *NamingError, so their instances never share a service
+func cloudMapServiceNames(targets Targets) (map[string]map[string]string, error) {
+
+	claims := map[string][]string{}
+	names := map[string]map[string]string{}
+
+	for group, service := range targets {
+		for container := range service {
+
+			name := cloudMapServiceName(group, container)
+			claims[strings.ToLower(name)] = append(claims[strings.ToLower(name)], fmt.Sprintf("%s:%s", group, container))
+
+			if names[group] == nil {
+				names[group] = map[string]string{}
+			}
+
+			names[group][container] = name
+		}
+	}
+
+	e := &NamingError{}
+
+	for name, keys := range claims {
+
+		if len(keys) == 1 {
+			continue
+		}
+
+		sort.Strings(keys)
+
+		e.Collisions = append(e.Collisions, NameCollision{Name: name, Keys: keys})
+
+		for _, k := range keys {
+			i := strings.Index(k, ":")
+			delete(names[k[:i]], k[i+1:])
+		}
+	}
+
+	if len(e.Collisions) == 0 {
+		return names, nil
+	}
+
+	sort.Slice(e.Collisions, func(i, j int) bool { return e.Collisions[i].Name < e.Collisions[j].Name })
+
+	return names, e
+}
+
+func cloudMapInstanceID(t *Target) string {
+	return fmt.Sprintf("%s:%d", t.IPAddress, t.Port)
+}
+
+func cloudMapAttributes(t *Target, owner string) map[string]*string {
+	attrs := map[string]*string{
+		"AWS_INSTANCE_IPV4": aws.String(t.IPAddress),
+		"AWS_INSTANCE_PORT": aws.String(strconv.FormatInt(t.Port, 10)),
+		cloudMapOwner:       aws.String(owner),
+	}
+
+	for k, v := range map[string]string{
+		"ECS_CLUSTER":           t.Cluster,
+		"ECS_GROUP":             t.Group,
+		"ECS_SERVICE":           t.Service,
+		"ECS_CONTAINER":         t.Name,
+		"ECS_TASK_ARN":          t.TaskArn,
+		"ECS_AVAILABILITY_ZONE": t.AvailabilityZone,
+		"EC2_INSTANCE_ID":       t.InstanceID,
+	} {
+		// Cloud Map doesn't accept empty attribute values
+		if v != "" {
+			attrs[k] = aws.String(v)
+		}
+	}
+
+	return attrs
+}
+
+func equalAttributes(a, b map[string]*string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if aws.StringValue(v) != aws.StringValue(b[k]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/stretchr/testify/assert"
+)
+
+//stubCloudMapClient keeps services and instances in memory
+type stubCloudMapClient struct {
+	services  map[string]*servicediscovery.ServiceSummary
+	instances map[string]map[string]map[string]*string
+}
+
+func newStubCloudMapClient() *stubCloudMapClient {
+	return &stubCloudMapClient{
+		services: map[string]*servicediscovery.ServiceSummary{
+			"srv-other": {Id: aws.String("srv-other"), Name: aws.String("other"), Description: aws.String("not ours")},
+		},
+		instances: map[string]map[string]map[string]*string{
+			"srv-other": {"10.0.0.1:80": {"AWS_INSTANCE_IPV4": aws.String("10.0.0.1")}},
+		},
+	}
+}
+
+func (s *stubCloudMapClient) GetNamespaceWithContext(aws.Context, *servicediscovery.GetNamespaceInput, ...request.Option) (*servicediscovery.GetNamespaceOutput, error) {
+	return &servicediscovery.GetNamespaceOutput{Namespace: &servicediscovery.Namespace{Type: aws.String(servicediscovery.NamespaceTypeDnsPrivate)}}, nil
+}
+
+func (s *stubCloudMapClient) ListServicesPagesWithContext(ctx aws.Context, i *servicediscovery.ListServicesInput, f func(*servicediscovery.ListServicesOutput, bool) bool, opts ...request.Option) error {
+	o := &servicediscovery.ListServicesOutput{}
+
+	for _, v := range s.services {
+		o.Services = append(o.Services, v)
+	}
+
+	f(o, true)
+
+	return nil
+}
+
+func (s *stubCloudMapClient) CreateServiceWithContext(ctx aws.Context, i *servicediscovery.CreateServiceInput, opts ...request.Option) (*servicediscovery.CreateServiceOutput, error) {
+	id := "srv-" + *i.Name
+
+	s.services[id] = &servicediscovery.ServiceSummary{Id: aws.String(id), Name: i.Name, Description: i.Description, DnsConfig: i.DnsConfig}
+	s.instances[id] = map[string]map[string]*string{}
+
+	return &servicediscovery.CreateServiceOutput{Service: &servicediscovery.Service{Id: aws.String(id)}}, nil
+}
+
+func (s *stubCloudMapClient) ListInstancesPagesWithContext(ctx aws.Context, i *servicediscovery.ListInstancesInput, f func(*servicediscovery.ListInstancesOutput, bool) bool, opts ...request.Option) error {
+	o := &servicediscovery.ListInstancesOutput{}
+
+	for id, attrs := range s.instances[*i.ServiceId] {
+		o.Instances = append(o.Instances, &servicediscovery.InstanceSummary{Id: aws.String(id), Attributes: attrs})
+	}
+
+	f(o, true)
+
+	return nil
+}
+
+func (s *stubCloudMapClient) RegisterInstanceWithContext(ctx aws.Context, i *servicediscovery.RegisterInstanceInput, opts ...request.Option) (*servicediscovery.RegisterInstanceOutput, error) {
+	s.instances[*i.ServiceId][*i.InstanceId] = i.Attributes
+
+	return &servicediscovery.RegisterInstanceOutput{}, nil
+}
+
+func (s *stubCloudMapClient) DeregisterInstanceWithContext(ctx aws.Context, i *servicediscovery.DeregisterInstanceInput, opts ...request.Option) (*servicediscovery.DeregisterInstanceOutput, error) {
+	delete(s.instances[*i.ServiceId], *i.InstanceId)
+
+	return &servicediscovery.DeregisterInstanceOutput{}, nil
+}
+
+func cloudMapTargets(ips ...string) Targets {
+	t := Targets{"group1": {"container1": []*Target{}}}
+
+	for _, ip := range ips {
+		t["group1"]["container1"] = append(t["group1"]["container1"], &Target{Name: "container1", Group: "group1", Cluster: "cluster1", IPAddress: ip, Port: 1234})
+	}
+
+	return t
+}
+
+func TestCloudMapSync(t *testing.T) {
+
+	client := newStubCloudMapClient()
+	c := &CloudMap{NamespaceID: "ns-1", Client: client}
+
+	n, err := c.Sync(context.Background(), cloudMapTargets("1.2.3.4", "1.2.3.5"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "container1.group1", *client.services["srv-container1.group1"].Name)
+	assert.Equal(t, servicediscovery.RecordTypeSrv, *client.services["srv-container1.group1"].DnsConfig.DnsRecords[0].Type)
+
+	attrs := client.instances["srv-container1.group1"]["1.2.3.4:1234"]
+
+	assert.Equal(t, "1.2.3.4", *attrs["AWS_INSTANCE_IPV4"])
+	assert.Equal(t, "1234", *attrs["AWS_INSTANCE_PORT"])
+	assert.Equal(t, "cluster1", *attrs["ECS_CLUSTER"])
+
+	n, err = c.Sync(context.Background(), cloudMapTargets("1.2.3.4", "1.2.3.5"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "unchanged instances shouldn't be registered again")
+
+	n, err = c.Sync(context.Background(), cloudMapTargets("1.2.3.4"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, client.instances["srv-container1.group1"], 1)
+}
+
+func TestCloudMapPrune(t *testing.T) {
+
+	client := newStubCloudMapClient()
+	c := &CloudMap{NamespaceID: "ns-1", Client: client}
+
+	c.Sync(context.Background(), cloudMapTargets("1.2.3.4", "1.2.3.5"))
+
+	n, err := c.Prune(context.Background(), Targets{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Len(t, client.instances["srv-container1.group1"], 0)
+	assert.Len(t, client.instances["srv-other"], 1, "instances of unmanaged services are left alone")
+}
+
+func TestCloudMapServiceNames(t *testing.T) {
+
+	client := newStubCloudMapClient()
+	c := &CloudMap{NamespaceID: "ns-1", Client: client}
+
+	targets := Targets{"web": {
+		"my_app": []*Target{{Name: "my_app", Group: "web", IPAddress: "1.2.3.4", Port: 80}},
+		"my-app": []*Target{{Name: "my-app", Group: "web", IPAddress: "1.2.3.5", Port: 80}},
+		"my.app": []*Target{{Name: "my.app", Group: "web", IPAddress: "1.2.3.6", Port: 80}},
+	}}
+
+	// valid names are kept as they are, names sanitized into the same service are reported and left out
+	n, err := c.Sync(context.Background(), targets)
+
+	assert.IsType(t, &NamingError{}, err)
+	assert.Equal(t, []NameCollision{{Name: "my-app.web", Keys: []string{"web:my-app", "web:my.app"}}}, err.(*NamingError).Collisions)
+	assert.Equal(t, 1, n)
+	assert.Len(t, client.instances["srv-my_app.web"], 1)
+	assert.NotContains(t, client.services, "srv-my-app.web")
+
+	// instances left in the service of an earlier name are pruned
+	client.services["srv-my-app.web"] = &servicediscovery.ServiceSummary{Id: aws.String("srv-my-app.web"), Name: aws.String("my-app.web"), Description: aws.String(cloudMapDescription)}
+	client.instances["srv-my-app.web"] = map[string]map[string]*string{"1.2.3.4:80": cloudMapAttributes(targets["web"]["my_app"][0], "managed:web:my_app")}
+
+	n, err = c.Prune(context.Background(), targets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, client.instances["srv-my-app.web"], 0)
+	assert.Len(t, client.instances["srv-my_app.web"], 1)
+}
+
+func TestCloudMapPruneGuard(t *testing.T) {
+
+	client := newStubCloudMapClient()
+	c := &CloudMap{NamespaceID: "ns-1", Client: client, Guard: PruneGuard{MaxDeletePercent: 50}}
+
+	c.Sync(context.Background(), cloudMapTargets("1.2.3.4", "1.2.3.5", "1.2.3.6"))
+
+	n, err := c.Prune(context.Background(), Targets{})
+
+	assert.IsType(t, &PruneLimitError{}, err)
+	assert.Equal(t, 0, n)
+	assert.Len(t, client.instances["srv-container1.group1"], 3, "nothing is deleted when the prune is refused")
+
+	n, err = c.Prune(context.Background(), cloudMapTargets("1.2.3.4", "1.2.3.5"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	c.Guard.Force = true
+
+	n, err = c.Prune(context.Background(), Targets{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
@@ -1,7 +1,230 @@
 package lib
 
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
 //Config holds the configuration for services and backends
 type Config struct {
-	Region, Cluster, Zone, Domain string
-	Interval                      int64
+	Region, Zone, Domain       string
+	Clusters                   []ClusterConfig
+	Filter                     Filter
+	Interval, ReadyIntervals   int64
+	ShutdownTimeout            int64
+	NameTemplate               string
+	ListenAddress              string
+	FileSDPath, FileSDFormat   string
+	Sinks                      []string
+	CloudMapNamespaceID        string
+	ConsulAddress, ConsulToken string
+	DNSAddress                 string
+	DNSTTL                     int64
+	PrivateZone, CreateZone    bool
+	//Instance scopes the managed records to this ecs-dns instance, see Owner
+	Instance     string
+	RemoveOnExit bool
+	//LeaseTable enables leader election with a lease named LeaseName lasting LeaseTTL seconds
+	LeaseTable, LeaseName string
+	LeaseTTL              int64
+	//MaxDeletePercent limits the share of managed records a prune deletes, unless it deletes at most MinDeleteCount.
+	//Force lifts the limit and is only set by sync --force
+	MaxDeletePercent float64
+	MinDeleteCount   int
+	Force            bool
+
+	RFC2136Server, RFC2136TSIGKeyName, RFC2136TSIGSecret, RFC2136TSIGAlgorithm string
+}
+
+//ClusterConfig is an ECS cluster to discover targets in, Region defaults to Config.Region
+type ClusterConfig struct {
+	Name   string `mapstructure:"name"`
+	Region string `mapstructure:"region"`
+}
+
+//ConfigError lists every problem found validating a Config
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(e.Problems, "; "))
+}
+
+//pruneGuard limits the prunes of the sinks created from the configuration
+func (c *Config) pruneGuard() PruneGuard {
+	return PruneGuard{MaxDeletePercent: c.MaxDeletePercent, MinDeleteCount: c.MinDeleteCount, Force: c.Force}
+}
+
+//Validate checks the configuration before anything is started, returning a *ConfigError listing every problem found
+func (c *Config) Validate() error {
+
+	e := &ConfigError{}
+	problem := func(format string, a ...interface{}) {
+		e.Problems = append(e.Problems, fmt.Sprintf(format, a...))
+	}
+
+	if len(c.Clusters) == 0 {
+		problem("cluster is required")
+	}
+
+	for i, cl := range c.Clusters {
+		if cl.Name == "" {
+			problem("clusters[%d] has no name", i)
+		}
+	}
+
+	if err := c.Filter.Validate(); err != nil {
+		problem("%v", err)
+	}
+
+	if c.Region == "" {
+		problem("region is required")
+	}
+
+	if c.Interval <= 0 {
+		problem("interval must be positive, got %d", c.Interval)
+	}
+
+	if c.ReadyIntervals <= 0 {
+		problem("ready-intervals must be positive, got %d", c.ReadyIntervals)
+	}
+
+	if err := ValidateOwner(c.Instance); err != nil {
+		problem("%v", err)
+	}
+
+	if c.RemoveOnExit && c.Instance == "" {
+		problem("remove-on-exit requires instance so only the records of this instance are removed")
+	}
+
+	if c.LeaseTable != "" && c.LeaseName == "" {
+		problem("lease-name is required by leader election")
+	}
+
+	if c.LeaseTable != "" && c.LeaseTTL < 3 {
+		problem("lease-ttl must be at least 3 seconds, got %d", c.LeaseTTL)
+	}
+
+	if c.MaxDeletePercent < 0 || c.MaxDeletePercent > 100 {
+		problem("max-delete-percent must be between 0 and 100, got %g", c.MaxDeletePercent)
+	}
+
+	if c.MinDeleteCount < 0 {
+		problem("min-delete-count must not be negative, got %d", c.MinDeleteCount)
+	}
+
+	if c.ShutdownTimeout < 0 {
+		problem("shutdown-timeout can't be negative, got %d", c.ShutdownTimeout)
+	}
+
+	if c.DNSTTL < 0 {
+		problem("dns-ttl can't be negative, got %d", c.DNSTTL)
+	}
+
+	for name, addr := range map[string]string{"listen-address": c.ListenAddress, "dns-address": c.DNSAddress} {
+		if _, _, err := net.SplitHostPort(addr); addr != "" && err != nil {
+			problem("%s %q: %v", name, addr, err)
+		}
+	}
+
+	if len(c.Sinks) == 0 {
+		problem("at least one sink is required")
+	}
+
+	sinks := map[string]bool{}
+
+	for _, s := range c.Sinks {
+		if _, found := sinkFactories[s]; !found {
+			problem("unknown sink %q, expected one of %s", s, strings.Join(SinkNames(), ", "))
+		}
+
+		sinks[s] = true
+	}
+
+	if (sinks["route53"] || sinks["rfc2136"]) && c.Domain == "" {
+		problem("domain is required by the route53 and rfc2136 sinks")
+	}
+
+	if c.Domain != "" {
+		if _, err := NewNaming(c.NameTemplate, c.Domain); err != nil {
+			problem("name-template: %v", err)
+		}
+	}
+
+	if sinks["route53"] && c.Zone != "" && c.CreateZone {
+		problem("zone and create-zone are mutually exclusive")
+	}
+
+	if sinks["file_sd"] && c.FileSDPath == "" {
+		problem("file-sd-path is required by the file_sd sink")
+	}
+
+	if f := strings.ToLower(c.FileSDFormat); f != "" && f != "json" && f != "yaml" {
+		problem("file-sd-format must be json or yaml, got %q", c.FileSDFormat)
+	}
+
+	if sinks["http_sd"] && c.ListenAddress == "" {
+		problem("listen-address is required by the http_sd sink")
+	}
+
+	if sinks["cloudmap"] && c.CloudMapNamespaceID == "" {
+		problem("cloudmap-namespace is required by the cloudmap sink")
+	}
+
+	if sinks["consul"] {
+		if _, err := url.Parse(c.ConsulAddress); err != nil {
+			problem("consul-address: %v", err)
+		}
+	}
+
+	if sinks["rfc2136"] {
+		if c.RFC2136Server == "" {
+			problem("rfc2136-server is required by the rfc2136 sink")
+		}
+
+		if _, err := base64.StdEncoding.DecodeString(c.RFC2136TSIGSecret); c.RFC2136TSIGKeyName != "" && err != nil {
+			problem("rfc2136-tsig-secret must be base64: %v", err)
+		}
+	}
+
+	if len(e.Problems) > 0 {
+		sort.Strings(e.Problems)
+		return e
+	}
+
+	return nil
+}
+
+//secretFields are reported as changed without their values
+var secretFields = map[string]bool{"ConsulToken": true, "RFC2136TSIGSecret": true}
+
+//Diff lists the settings that differ from old as "Field: old -> new"
+func (c *Config) Diff(old *Config) []string {
+
+	changes := []string{}
+	a, b := reflect.ValueOf(*old), reflect.ValueOf(*c)
+
+	for i := 0; i < a.NumField(); i++ {
+		name := a.Type().Field(i).Name
+		o, n := a.Field(i).Interface(), b.Field(i).Interface()
+
+		if reflect.DeepEqual(o, n) {
+			continue
+		}
+
+		if secretFields[name] {
+			changes = append(changes, fmt.Sprintf("%s changed", name))
+			continue
+		}
+
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, o, n))
+	}
+
+	return changes
 }
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Region:         "us-east-1",
+		Clusters:       []ClusterConfig{{Name: "cluster1"}},
+		Domain:         "production1.ecs",
+		Interval:       10,
+		ReadyIntervals: 3,
+		NameTemplate:   DefaultNameTemplate,
+		ListenAddress:  ":8080",
+		Sinks:          []string{"route53"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	assert.NoError(t, validConfig().Validate())
+
+	c := validConfig()
+	c.Clusters = nil
+	c.Interval = 0
+	c.ShutdownTimeout = -1
+	c.ListenAddress = "8080"
+	c.Sinks = []string{"route53", "file_sd", "bogus"}
+
+	err := c.Validate()
+
+	assert.IsType(t, &ConfigError{}, err)
+	assert.Equal(t, []string{
+		"cluster is required",
+		"file-sd-path is required by the file_sd sink",
+		"interval must be positive, got 0",
+		`listen-address "8080": address 8080: missing port in address`,
+		"shutdown-timeout can't be negative, got -1",
+		`unknown sink "bogus", expected one of ` + strings.Join(SinkNames(), ", "),
+	}, err.(*ConfigError).Problems)
+}
+
+func TestValidateSinkOptions(t *testing.T) {
+
+	c := validConfig()
+	c.Domain = ""
+	c.Sinks = []string{"rfc2136", "cloudmap"}
+	c.RFC2136TSIGKeyName = "ecs-dns"
+	c.RFC2136TSIGSecret = "not base64!"
+
+	err := c.Validate()
+
+	assert.Error(t, err)
+	assert.Len(t, err.(*ConfigError).Problems, 4)
+
+	// http_sd doesn't name records so it needs no domain
+	c = validConfig()
+	c.Domain = ""
+	c.Sinks = []string{"http_sd"}
+
+	assert.NoError(t, c.Validate())
+
+	c = validConfig()
+	c.NameTemplate = "{{.Container"
+
+	assert.Error(t, c.Validate())
+
+	c = validConfig()
+	c.RemoveOnExit = true
+
+	assert.Error(t, c.Validate())
+
+	c.Instance = "preview-42"
+
+	assert.NoError(t, c.Validate())
+
+	c = validConfig()
+	c.MaxDeletePercent = 150
+
+	assert.Error(t, c.Validate())
+
+	c = validConfig()
+	c.MinDeleteCount = -1
+
+	assert.Error(t, c.Validate())
+
+	c = validConfig()
+	c.Sinks = []string{"http_sd"}
+	c.ListenAddress = ""
+
+	assert.Error(t, c.Validate())
+
+	c = validConfig()
+	c.LeaseTable = "ecs-dns-leases"
+	c.LeaseTTL = 1
+
+	assert.Error(t, c.Validate())
+
+	c.LeaseName = "ecs-dns"
+	c.LeaseTTL = 15
+
+	assert.NoError(t, c.Validate())
+}
+
+func TestDiff(t *testing.T) {
+
+	old := validConfig()
+	c := validConfig()
+
+	assert.Empty(t, c.Diff(old))
+
+	c.Interval = 20
+	c.Filter.Exclude = []string{"*-canary"}
+	c.ConsulToken = "secret"
+
+	assert.Equal(t, []string{
+		"Filter: {[] [] []} -> {[] [*-canary] []}",
+		"Interval: 10 -> 20",
+		"ConsulToken changed",
+	}, c.Diff(old))
+}
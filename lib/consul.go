@@ -0,0 +1,275 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+//consulOwner is the service meta key holding the owner identifier
+const consulOwner = "ecs_dns_owner"
+
+func init() {
+	RegisterSink("consul", func(c *Config) (DNS, error) {
+		return &Consul{Address: c.ConsulAddress, Token: c.ConsulToken, Owner: Owner(c.Instance), Guard: c.pruneGuard()}, nil
+	})
+}
+
+//Consul registers targets as service instances with the local Consul agent.
+//Targets are registered under the group name tagged with the container name, so they resolve as <container>.<group>.service.consul
+type Consul struct {
+	Address string
+	Token   string
+	Owner   Owner
+	Guard   PruneGuard
+	Client  *http.Client
+}
+
+//ConsulService is a service instance registered with the Consul agent
+type ConsulService struct {
+	ID      string
+	Service string
+	Tags    []string
+	Address string
+	Port    int64
+	Meta    map[string]string
+}
+
+//consulRegistration is the body of an agent service registration
+type consulRegistration struct {
+	ID      string
+	Name    string
+	Tags    []string
+	Address string
+	Port    int64
+	Meta    map[string]string
+}
+
+//Sync registers new or changed targets and deregisters the instances of the synced groups and containers no longer in targets
+func (c *Consul) Sync(ctx context.Context, targets Targets) (int, error) {
+
+	existing, err := c.services(ctx)
+
+	if err != nil {
+		glog.Error(err)
+		return 0, err
+	}
+
+	changes := 0
+	desired := map[string]bool{}
+
+	for group, service := range targets {
+		for container, ts := range service {
+
+			owner := c.Owner.id(group, container)
+
+			for _, t := range ts {
+				s := consulService(t, owner)
+
+				desired[s.ID] = true
+
+				if e, found := existing[s.ID]; found && e.Service == s.Name && e.Address == s.Address && e.Port == s.Port &&
+					reflect.DeepEqual(e.Tags, s.Tags) && reflect.DeepEqual(e.Meta, s.Meta) {
+					continue
+				}
+
+				glog.Infof("Registering Consul service %s", s.ID)
+
+				if err := c.do(ctx, "PUT", "/v1/agent/service/register", s, nil); err != nil {
+					glog.Error(err)
+					return changes, err
+				}
+
+				changes++
+			}
+		}
+	}
+
+	for id, s := range existing {
+		group, container, _ := c.Owner.parse(s.Meta[consulOwner])
+
+		if desired[id] || targets[group][container] == nil {
+			continue
+		}
+
+		if err := c.deregister(ctx, id); err != nil {
+			glog.Error(err)
+			return changes, err
+		}
+
+		changes++
+	}
+
+	return changes, nil
+}
+
+//Prune deregisters managed instances no longer registered with the backend
+func (c *Consul) Prune(ctx context.Context, targets Targets) (int, error) {
+
+	desired := map[string]bool{}
+
+	for _, service := range targets {
+		for _, ts := range service {
+			for _, t := range ts {
+				desired[consulServiceID(t)] = true
+			}
+		}
+	}
+
+	return c.deregisterManaged(ctx, c.Guard, func(id string) bool { return !desired[id] })
+}
+
+//RemoveAllManagedRecords deregisters every managed instance
+func (c *Consul) RemoveAllManagedRecords(ctx context.Context) (int, error) {
+	return c.deregisterManaged(ctx, PruneGuard{}, func(string) bool { return true })
+}
+
+func (c *Consul) deregisterManaged(ctx context.Context, guard PruneGuard, remove func(id string) bool) (int, error) {
+
+	existing, err := c.services(ctx)
+
+	if err != nil {
+		glog.Error(err)
+		return 0, err
+	}
+
+	removes := []string{}
+
+	for id := range existing {
+		if remove(id) {
+			removes = append(removes, id)
+		}
+	}
+
+	if err := guard.Check(len(existing), len(removes)); err != nil {
+		glog.Error(err)
+		return 0, err
+	}
+
+	changes := 0
+
+	for _, id := range removes {
+		if err := c.deregister(ctx, id); err != nil {
+			glog.Error(err)
+			return changes, err
+		}
+
+		changes++
+	}
+
+	return changes, nil
+}
+
+func (c *Consul) deregister(ctx context.Context, id string) error {
+	glog.Infof("Deregistering Consul service %s", id)
+
+	return c.do(ctx, "PUT", "/v1/agent/service/deregister/"+url.PathEscape(id), nil, nil)
+}
+
+//services returns the managed service instances registered with the agent keyed by id
+func (c *Consul) services(ctx context.Context) (map[string]*ConsulService, error) {
+	all := map[string]*ConsulService{}
+
+	if err := c.do(ctx, "GET", "/v1/agent/services", nil, &all); err != nil {
+		return nil, err
+	}
+
+	managed := map[string]*ConsulService{}
+
+	for id, s := range all {
+		if c.Owner.owns(s.Meta[consulOwner]) {
+			managed[id] = s
+		}
+	}
+
+	return managed, nil
+}
+
+func (c *Consul) do(ctx context.Context, method, path string, body, out interface{}) error {
+
+	var b bytes.Buffer
+
+	if body != nil {
+		if err := json.NewEncoder(&b).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	addr := c.Address
+
+	if addr == "" {
+		addr = "http://127.0.0.1:8500"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(addr, "/")+path, &b)
+
+	if err != nil {
+		return err
+	}
+
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	client := c.Client
+
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("consul %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func consulServiceID(t *Target) string {
+	return fmt.Sprintf("ecs-dns:%s:%s:%s:%d", t.Group, t.Name, t.IPAddress, t.Port)
+}
+
+func consulService(t *Target, owner string) *consulRegistration {
+	meta := map[string]string{consulOwner: owner}
+
+	for k, v := range map[string]string{
+		"ecs_cluster":           t.Cluster,
+		"ecs_task_arn":          t.TaskArn,
+		"ecs_availability_zone": t.AvailabilityZone,
+		"ec2_instance_id":       t.InstanceID,
+	} {
+		if v != "" {
+			meta[k] = v
+		}
+	}
+
+	return &consulRegistration{
+		ID:      consulServiceID(t),
+		Name:    SanitizeLabel(t.Group),
+		Tags:    []string{SanitizeLabel(t.Name), "ecs-dns"},
+		Address: t.IPAddress,
+		Port:    t.Port,
+		Meta:    meta,
+	}
+}
@@ -0,0 +1,280 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+var (
+	//ErrPartialDiscovery matches the error of a discovery that skipped some tasks, the targets it returned are incomplete
+	ErrPartialDiscovery = errors.New("partial discovery")
+	//ErrThrottled matches the error of a discovery call AWS throttled, retrying later is expected to succeed
+	ErrThrottled = errors.New("throttled by aws")
+
+	errContainerInstanceNotFound = errors.New("container instance not found")
+	errNoPrivateIP               = errors.New("instance has no private ip address")
+	errInstanceNotFound          = errors.New("instance not found")
+)
+
+//DiscoveryError is an ECS or EC2 call of a cluster that failed, ARN is the resource it failed for when known.
+//Group is the group of the task it skipped when known, so the records of that group can be left alone
+type DiscoveryError struct {
+	Cluster string
+	Op      string
+	ARN     string
+	Group   string
+	Err     error
+}
+
+func (e *DiscoveryError) Error() string {
+	if e.ARN == "" {
+		return fmt.Sprintf("cluster %s: %s: %v", e.Cluster, e.Op, e.Err)
+	}
+
+	return fmt.Sprintf("cluster %s: %s %s: %v", e.Cluster, e.Op, e.ARN, e.Err)
+}
+
+func (e *DiscoveryError) Unwrap() error {
+	return e.Err
+}
+
+//Is matches ErrThrottled when AWS throttled the call
+func (e *DiscoveryError) Is(target error) bool {
+	return target == ErrThrottled && request.IsErrorThrottle(e.Err)
+}
+
+//DiscoveryErrors lists the tasks a discovery skipped and the services it failed to look up, it is returned along with the
+//targets that were found
+type DiscoveryErrors []*DiscoveryError
+
+func (e DiscoveryErrors) Error() string {
+	m := []string{}
+	counts := map[string]int{}
+	ops := []string{}
+
+	for _, err := range e {
+		m = append(m, err.Error())
+
+		if counts[err.Op] == 0 {
+			ops = append(ops, err.Op)
+		}
+
+		counts[err.Op]++
+	}
+
+	summary := []string{}
+
+	for _, op := range ops {
+		if serviceOps[op] && counts[op] == 1 && e.unknown(op) {
+			summary = append(summary, fmt.Sprintf("%s failed, tasks have no service metadata", op))
+		} else if serviceOps[op] {
+			summary = append(summary, fmt.Sprintf("%s failed for %s, their tasks have no service metadata", op, plural(counts[op], "service")))
+		} else {
+			summary = append(summary, fmt.Sprintf("%s skipped %s", op, plural(counts[op], "task")))
+		}
+	}
+
+	return fmt.Sprintf("partial discovery, %s: %s", strings.Join(summary, ", "), strings.Join(m, "; "))
+}
+
+//serviceOps are the calls looking up service metadata, their failures skip no task
+var serviceOps = map[string]bool{"ListServices": true, "DescribeServices": true}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+//unknown reports whether op failed for the whole cluster rather than for some resources
+func (e DiscoveryErrors) unknown(op string) bool {
+	for _, err := range e {
+		if err.Op == op && err.ARN == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Is matches ErrPartialDiscovery, and ErrThrottled when any of the calls was throttled
+func (e DiscoveryErrors) Is(target error) bool {
+	if target == ErrPartialDiscovery {
+		return true
+	}
+
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Skipped returns the groups of the tasks the discovery skipped, false when the group of one of them isn't known
+func (e DiscoveryErrors) Skipped() (map[string]bool, bool) {
+	groups := map[string]bool{}
+
+	for _, err := range e {
+		if serviceOps[err.Op] {
+			continue
+		}
+
+		if err.Group == "" {
+			return nil, false
+		}
+
+		groups[err.Group] = true
+	}
+
+	return groups, true
+}
+
+//CarryOver returns the targets of a discovery with the groups of the tasks it skipped replaced by their previous
+//targets, so syncing them leaves the records of those groups as they are. Syncing a partial discovery as is would
+//drop the records of the skipped tasks, as sinks replace the records of every group they are given.
+//It returns false when the sync has to be skipped: the group of a skipped task isn't known, or previous is nil
+//because nothing was synced yet
+func CarryOver(targets, previous Targets, err error) (Targets, bool) {
+
+	var errs DiscoveryErrors
+
+	if err == nil {
+		return targets, true
+	} else if !errors.As(err, &errs) {
+		return nil, false
+	}
+
+	groups, known := errs.Skipped()
+
+	if !known || previous == nil {
+		return nil, false
+	}
+
+	s := make(Targets)
+
+	for group, service := range targets {
+		if !groups[group] {
+			s[group] = service
+		}
+	}
+
+	for group := range groups {
+		if service, found := previous[group]; found {
+			s[group] = service
+		}
+	}
+
+	return s, true
+}
+
+func (e *ECSCluster) discoveryError(op, arn string, err error) *DiscoveryError {
+	return &DiscoveryError{Cluster: e.Cluster, Op: op, ARN: arn, Err: err}
+}
+
+//taskError is a discoveryError for a task that was skipped
+func (e *ECSCluster) taskError(op, arn string, task *ecs.Task, err error) *DiscoveryError {
+	d := e.discoveryError(op, arn, err)
+	d.Group = groupName(task)
+
+	return d
+}
+
+//groupName returns the group of a task, the service name for the tasks of a service
+func groupName(task *ecs.Task) string {
+	parts := strings.Split(aws.StringValue(task.Group), ":")
+
+	if len(parts) < 2 {
+		return parts[0]
+	}
+
+	return parts[1]
+}
+
+//DefaultDescribeConcurrency is how many describe calls a cluster makes at once when ECSCluster.DescribeConcurrency is zero
+const DefaultDescribeConcurrency = 4
+
+//failureMissing is the reason ECS gives for a resource that no longer exists, like a task that stopped since it was listed
+const failureMissing = "MISSING"
+
+//chunk splits arns into slices of at most size
+func chunk(arns []*string, size int) [][]*string {
+	chunks := [][]*string{}
+
+	for start := 0; start < len(arns); start += size {
+		end := start + size
+
+		if end > len(arns) {
+			end = len(arns)
+		}
+
+		chunks = append(chunks, arns[start:end])
+	}
+
+	return chunks
+}
+
+//describeConcurrently calls describe for every chunk with at most DescribeConcurrency calls at once.
+//It stops starting calls after the first error, which it returns once the calls in flight are done
+func (e *ECSCluster) describeConcurrently(ctx context.Context, chunks [][]*string, describe func(ctx context.Context, i int, arns []*string) error) error {
+
+	n := e.DescribeConcurrency
+
+	if n <= 0 {
+		n = DefaultDescribeConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	slots := make(chan struct{}, n)
+
+	for i, arns := range chunks {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+
+		go func(i int, arns []*string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := describe(ctx, i, arns); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i, arns)
+	}
+
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return firstErr
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCarryOver(t *testing.T) {
+
+	previous := Targets{
+		"web":    {"app": {{Name: "app", IPAddress: "10.0.0.1"}, {Name: "app", IPAddress: "10.0.0.2"}}},
+		"worker": {"app": {{Name: "app", IPAddress: "10.0.0.3"}}},
+	}
+
+	// the task on 10.0.0.2 was skipped, and worker scaled to a new task
+	targets := Targets{
+		"web":    {"app": {{Name: "app", IPAddress: "10.0.0.1"}}},
+		"worker": {"app": {{Name: "app", IPAddress: "10.0.0.4"}}},
+	}
+
+	skipped := DiscoveryErrors{{Cluster: "cluster1", Op: "DescribeTaskDefinition", ARN: "taskdef-arn1", Group: "web", Err: errors.New("boom")}}
+
+	carried, ok := CarryOver(targets, previous, skipped)
+
+	assert.True(t, ok)
+	assert.Equal(t, previous["web"], carried["web"])
+	assert.Equal(t, targets["worker"], carried["worker"])
+
+	// a group that had no records keeps none
+	carried, ok = CarryOver(targets, Targets{"worker": previous["worker"]}, skipped)
+
+	assert.True(t, ok)
+	assert.NotContains(t, carried, "web")
+
+	// nothing to carry over from, or a skipped task of an unknown group, skips the sync
+	_, ok = CarryOver(targets, nil, skipped)
+	assert.False(t, ok)
+
+	_, ok = CarryOver(targets, previous, DiscoveryErrors{{Cluster: "cluster1", Op: "DescribeTasks", ARN: "task-1", Err: errors.New("boom")}})
+	assert.False(t, ok)
+
+	_, ok = CarryOver(nil, previous, &DiscoveryError{Cluster: "cluster1", Op: "ListTasks", Err: errors.New("boom")})
+	assert.False(t, ok)
+
+	carried, ok = CarryOver(targets, nil, nil)
+
+	assert.True(t, ok)
+	assert.Equal(t, targets, carried)
+}
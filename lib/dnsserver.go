@@ -0,0 +1,269 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+//DNSServer answers SRV, A and TXT queries for the domain from the latest targets.
+//SRV records target a host name per address, <ip-with-dashes>.<domain>, answering its A or AAAA record
+type DNSServer struct {
+	Domain string
+	TTL    uint32
+	Naming *Naming
+	Owner  Owner
+
+	mu      sync.RWMutex
+	records map[string][]dns.RR
+	//names holds every name in the zone including empty non-terminals, which answer NODATA rather than NXDOMAIN
+	names  map[string]bool
+	serial uint32
+}
+
+//Sync replaces the records served with those of the targets
+func (s *DNSServer) Sync(ctx context.Context, targets Targets) (int, error) {
+
+	n := s.Naming
+
+	if n == nil {
+		var err error
+
+		if n, err = NewNaming(DefaultNameTemplate, s.Domain); err != nil {
+			return 0, err
+		}
+	}
+
+	names, nameErr := n.Names(targets)
+
+	if nameErr != nil {
+		glog.Error(nameErr)
+	}
+
+	zone := dns.Fqdn(strings.ToLower(s.Domain))
+	records := map[string][]dns.RR{}
+	all := map[string]bool{zone: true}
+	hosts := map[string]bool{}
+	synced := 0
+
+	for group, service := range names {
+		for container, name := range service {
+
+			fqdn := dns.Fqdn(strings.ToLower(name))
+			hdr := func(t uint16) dns.RR_Header {
+				return dns.RR_Header{Name: fqdn, Rrtype: t, Class: dns.ClassINET, Ttl: s.TTL}
+			}
+
+			seen := map[string]bool{}
+
+			for _, t := range targets[group][container] {
+				target := dns.Fqdn(t.IPAddress)
+
+				if host, rr := hostRecord(t.IPAddress, zone, s.TTL); rr != nil {
+					target = host
+
+					if !hosts[host] {
+						hosts[host], all[host] = true, true
+						records[host] = append(records[host], rr)
+					}
+				}
+
+				records[fqdn] = append(records[fqdn], &dns.SRV{Hdr: hdr(dns.TypeSRV), Priority: 1, Weight: 1, Port: uint16(t.Port), Target: target})
+
+				if ip := net.ParseIP(t.IPAddress).To4(); ip != nil && !seen[t.IPAddress] {
+					seen[t.IPAddress] = true
+					records[fqdn] = append(records[fqdn], &dns.A{Hdr: hdr(dns.TypeA), A: ip})
+				}
+			}
+
+			records[fqdn] = append(records[fqdn], &dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: []string{s.Owner.id(group, container)}})
+			synced++
+
+			for l := fqdn; l != zone && dns.IsSubDomain(zone, l); {
+				all[l] = true
+
+				i, end := dns.NextLabel(l, 0)
+
+				if end {
+					break
+				}
+
+				l = l[i:]
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = records
+	s.names = all
+	s.serial = uint32(time.Now().Unix())
+
+	return synced, nameErr
+}
+
+//hostRecord returns the host name of an address within zone, <ip-with-dashes>.<zone>, and its A or AAAA record, nil when ip isn't an address.
+//SRV records target it since their target must be a name, not an address
+func hostRecord(ip, zone string, ttl uint32) (string, dns.RR) {
+
+	addr := net.ParseIP(ip)
+
+	if addr == nil {
+		return "", nil
+	}
+
+	host := strings.NewReplacer(".", "-", ":", "-").Replace(addr.String()) + "." + zone
+	hdr := func(t uint16) dns.RR_Header {
+		return dns.RR_Header{Name: host, Rrtype: t, Class: dns.ClassINET, Ttl: ttl}
+	}
+
+	if v4 := addr.To4(); v4 != nil {
+		return host, &dns.A{Hdr: hdr(dns.TypeA), A: v4}
+	}
+
+	return host, &dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: addr}
+}
+
+//Prune is a no-op, Sync replaces all records
+func (s *DNSServer) Prune(ctx context.Context, targets Targets) (int, error) {
+	return 0, nil
+}
+
+//RemoveAllManagedRecords stops serving any records
+func (s *DNSServer) RemoveAllManagedRecords(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.records)
+	s.records = nil
+	s.names = nil
+
+	return n, nil
+}
+
+//ServeDNS answers queries for the domain, truncating UDP responses that don't fit so clients retry over TCP
+func (s *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	defer func() {
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+			size := dns.MinMsgSize
+
+			if o := r.IsEdns0(); o != nil {
+				size = int(o.UDPSize())
+				m.SetEdns0(o.UDPSize(), false)
+			}
+
+			m.Truncate(size)
+		}
+
+		if err := w.WriteMsg(m); err != nil {
+			glog.Error(err)
+		}
+	}()
+
+	if len(r.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		return
+	}
+
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+	zone := dns.Fqdn(strings.ToLower(s.Domain))
+
+	if !dns.IsSubDomain(zone, name) {
+		m.Authoritative = false
+		m.Rcode = dns.RcodeRefused
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	soa := s.soa(zone)
+
+	if name == zone && (q.Qtype == dns.TypeSOA || q.Qtype == dns.TypeANY) {
+		m.Answer = append(m.Answer, soa)
+		return
+	}
+
+	for _, rr := range s.records[name] {
+		if q.Qtype == dns.TypeANY || rr.Header().Rrtype == q.Qtype {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+
+	if len(m.Answer) > 0 {
+		m.Extra = s.additional(m.Answer)
+		return
+	}
+
+	// negative answers carry the SOA so resolvers can cache them
+	m.Ns = append(m.Ns, soa)
+
+	if !s.names[name] && name != zone {
+		m.Rcode = dns.RcodeNameError
+	}
+}
+
+//additional returns the address records of the hosts the SRV records of answer target, sparing resolvers a query per target
+func (s *DNSServer) additional(answer []dns.RR) []dns.RR {
+	extra := []dns.RR{}
+	seen := map[string]bool{}
+
+	for _, rr := range answer {
+		srv, ok := rr.(*dns.SRV)
+
+		if !ok || seen[srv.Target] {
+			continue
+		}
+
+		seen[srv.Target] = true
+
+		for _, a := range s.records[srv.Target] {
+			if t := a.Header().Rrtype; t == dns.TypeA || t == dns.TypeAAAA {
+				extra = append(extra, a)
+			}
+		}
+	}
+
+	return extra
+}
+
+func (s *DNSServer) soa(zone string) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: s.TTL},
+		Ns:      "ns." + zone,
+		Mbox:    "hostmaster." + zone,
+		Serial:  s.serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  s.TTL,
+	}
+}
+
+//ListenAndServe answers queries on addr over both UDP and TCP until one of the servers fails
+func (s *DNSServer) ListenAndServe(addr string) error {
+	errs := make(chan error, 2)
+
+	for _, proto := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: addr, Net: proto, Handler: s}
+
+		go func() {
+			errs <- fmt.Errorf("dns server %s %s: %v", srv.Net, srv.Addr, srv.ListenAndServe())
+		}()
+	}
+
+	return <-errs
+}
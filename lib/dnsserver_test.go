@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func startDNSServer(t *testing.T, s *DNSServer) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	udp := &dns.Server{PacketConn: pc, Handler: s}
+	tcp := &dns.Server{Listener: l, Handler: s}
+
+	go udp.ActivateAndServe()
+	go tcp.ActivateAndServe()
+
+	t.Cleanup(func() {
+		udp.Shutdown()
+		tcp.Shutdown()
+	})
+
+	return pc.LocalAddr().String()
+}
+
+func query(t *testing.T, proto, addr, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+
+	c := &dns.Client{Net: proto}
+
+	r, _, err := c.Exchange(m, addr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestDNSServer(t *testing.T) {
+
+	s := &DNSServer{Domain: "sandbox1.ecs", TTL: 5}
+
+	s.Sync(context.Background(), Targets{
+		"group1": {
+			"container1": []*Target{
+				{Name: "container1", Group: "group1", IPAddress: "1.2.3.4", Port: 1234},
+				{Name: "container1", Group: "group1", IPAddress: "1.2.3.5", Port: 1234},
+			},
+		},
+	})
+
+	addr := startDNSServer(t, s)
+
+	r := query(t, "udp", addr, "container1.group1.sandbox1.ecs.", dns.TypeSRV)
+
+	assert.Equal(t, dns.RcodeSuccess, r.Rcode)
+	assert.True(t, r.Authoritative)
+	assert.Len(t, r.Answer, 2)
+	assert.Equal(t, uint32(5), r.Answer[0].Header().Ttl)
+	assert.Equal(t, uint16(1234), r.Answer[0].(*dns.SRV).Port)
+	assert.Equal(t, "1-2-3-4.sandbox1.ecs.", r.Answer[0].(*dns.SRV).Target)
+	assert.Len(t, r.Extra, 2, "the addresses of the targets are additional records")
+
+	r = query(t, "udp", addr, "1-2-3-5.sandbox1.ecs.", dns.TypeA)
+
+	assert.Len(t, r.Answer, 1)
+	assert.Equal(t, "1.2.3.5", r.Answer[0].(*dns.A).A.String())
+
+	r = query(t, "udp", addr, "CONTAINER1.group1.sandbox1.ecs.", dns.TypeA)
+
+	assert.Len(t, r.Answer, 2)
+
+	r = query(t, "udp", addr, "container1.group1.sandbox1.ecs.", dns.TypeTXT)
+
+	assert.Equal(t, []string{"managed:group1:container1"}, r.Answer[0].(*dns.TXT).Txt)
+
+	r = query(t, "udp", addr, "container1.group1.sandbox1.ecs.", dns.TypeAAAA)
+
+	assert.Equal(t, dns.RcodeSuccess, r.Rcode, "NODATA for a missing type")
+	assert.Len(t, r.Answer, 0)
+	assert.IsType(t, &dns.SOA{}, r.Ns[0])
+
+	r = query(t, "udp", addr, "group1.sandbox1.ecs.", dns.TypeSRV)
+
+	assert.Equal(t, dns.RcodeSuccess, r.Rcode, "NODATA for an empty non-terminal")
+
+	r = query(t, "udp", addr, "missing.group1.sandbox1.ecs.", dns.TypeSRV)
+
+	assert.Equal(t, dns.RcodeNameError, r.Rcode)
+	assert.IsType(t, &dns.SOA{}, r.Ns[0])
+
+	r = query(t, "udp", addr, "example.com.", dns.TypeA)
+
+	assert.Equal(t, dns.RcodeRefused, r.Rcode)
+}
+
+func TestDNSServerTCPFallback(t *testing.T) {
+
+	s := &DNSServer{Domain: "sandbox1.ecs"}
+
+	containers := []*Target{}
+
+	for i := 0; i < 100; i++ {
+		containers = append(containers, &Target{Name: "container1", Group: "group1", IPAddress: fmt.Sprintf("10.0.0.%d", i), Port: 1234})
+	}
+
+	s.Sync(context.Background(), Targets{"group1": {"container1": containers}})
+
+	addr := startDNSServer(t, s)
+
+	m := new(dns.Msg)
+	m.SetQuestion("container1.group1.sandbox1.ecs.", dns.TypeSRV)
+
+	r, _, err := (&dns.Client{Net: "udp"}).Exchange(m, addr)
+
+	assert.Nil(t, err)
+	assert.True(t, r.Truncated)
+
+	r = query(t, "tcp", addr, "container1.group1.sandbox1.ecs.", dns.TypeSRV)
+
+	assert.False(t, r.Truncated)
+	assert.Len(t, r.Answer, 100)
+}
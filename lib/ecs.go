@@ -1,18 +1,20 @@
 package lib
 
 import (
+	"context"
 	"sort"
-	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/golang/glog"
-	"github.com/mitchellh/hashstructure"
 )
 
 //Backend has information about targets
 type Backend interface {
-	GetTargets() (Targets, error)
+	GetTargets(context.Context) (Targets, error)
 }
 
 //Target stores the containers scrapeable endpoint
@@ -21,52 +23,161 @@ type Target struct {
 	IPAddress string
 	Name      string
 	Group     string
+	Cluster   string
+	Family    string
+	PortName  string
+	Labels    map[string]string
+	Tags      map[string]string
+
+	TaskArn          string
+	Revision         int64
+	AvailabilityZone string
+	InstanceID       string
+
+	//Service is the ECS service that started the task, empty along with the other service fields for standalone tasks
+	Service      string
+	ServiceTags  map[string]string
+	DeploymentID string
+	DesiredCount int64
+	RunningCount int64
 }
 
 //Targets stores targets grouped by service and container
 type Targets map[string]map[string][]*Target
 
-//GetTargets combines host and container information to produce the scrapeable targets
-func (e *ECSCluster) GetTargets() (s Targets, err error) {
+//GetTargets combines host, service and container information to produce the scrapeable targets.
+//A failed listing or describe of the cluster returns a *DiscoveryError and no targets, while tasks and services that can't be
+//resolved are skipped and returned as DiscoveryErrors along with the targets of the others
+func (e *ECSCluster) GetTargets(ctx context.Context) (Targets, error) {
 
-	hosts, err := e.getHosts()
+	// services are discovered alongside the hosts and tasks, and cancelled when those fail
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var services map[string]*ecs.Service
+	var servicesErr error
+	fetched := make(chan struct{})
+
+	go func() {
+		defer close(fetched)
+		services, servicesErr = e.getServices(ctx)
+	}()
+
+	hosts, err := e.getHosts(ctx)
 
 	if err != nil {
 		glog.Error(err)
-		return
+		return nil, err
 	}
 
-	tasks, err := e.getTasks()
+	tasks, err := e.getTasks(ctx)
 
-	if err != nil {
+	partial, isPartial := err.(DiscoveryErrors)
+
+	if err != nil && !isPartial {
 		glog.Error(err)
-		return
+		return nil, err
 	}
 
-	s = make(Targets)
+	<-fetched
+
+	services, servicesErr = e.serviceMetadata(services, servicesErr)
+
+	if errs, ok := servicesErr.(DiscoveryErrors); ok {
+		partial = append(partial, errs...)
+	} else if servicesErr != nil {
+		glog.Error(servicesErr)
+		return nil, servicesErr
+	}
+
+	s := make(Targets)
 
 	for _, task := range tasks {
 
-		i, found := hosts[*task.ContainerInstanceArn]
+		i, found := hosts[aws.StringValue(task.ContainerInstanceArn)]
 
 		if !found {
-			glog.Errorf("Container Instance not found for task %s", *task.TaskArn)
+			partial = append(partial, e.taskError("DescribeContainerInstances", aws.StringValue(task.ContainerInstanceArn), task, errContainerInstanceNotFound))
+			continue
+		}
+
+		if i.PrivateIPAddress == nil {
+			err := errNoPrivateIP
+
+			if i.notFound {
+				err = errInstanceNotFound
+			}
+
+			partial = append(partial, e.taskError("DescribeInstances", aws.StringValue(i.InstanceID), task, err))
+			continue
+		}
+
+		group := groupName(task)
+		service := services[serviceName(task)]
+
+		if service != nil {
+			group = *service.ServiceName
+		}
+
+		td, err := e.getTaskDefinition(ctx, *task.TaskDefinitionArn)
+
+		if err != nil {
+			partial = append(partial, e.taskError("DescribeTaskDefinition", *task.TaskDefinitionArn, task, err))
 			continue
 		}
 
-		group := strings.Split(*task.Group, ":")[1]
+		tags := map[string]string{}
+
+		for _, t := range task.Tags {
+			tags[*t.Key] = *t.Value
+		}
+
+		serviceTags := map[string]string{}
+
+		if service != nil {
+			for _, t := range service.Tags {
+				serviceTags[*t.Key] = *t.Value
+			}
+		}
 
 		for _, c := range task.Containers {
 
 			if len(c.NetworkBindings) <= 0 {
 				continue
 			}
+
+			cd := containerDefinition(td, *c.Name)
+
 			//TODO uses first network binding but should use a docker label
 			target := &Target{
 				Port:      *c.NetworkBindings[0].HostPort,
 				Name:      *c.Name,
 				IPAddress: *i.PrivateIPAddress,
 				Group:     string(group),
+				Cluster:   e.Cluster,
+				Family:    *td.Family,
+				PortName:  portName(cd, c.NetworkBindings[0]),
+				Labels:    map[string]string{},
+				Tags:      tags,
+
+				TaskArn:          *task.TaskArn,
+				Revision:         *td.Revision,
+				AvailabilityZone: aws.StringValue(task.AvailabilityZone),
+				InstanceID:       *i.InstanceID,
+			}
+
+			if service != nil {
+				target.Service = *service.ServiceName
+				target.ServiceTags = serviceTags
+				target.DeploymentID = deploymentID(service, task)
+				target.DesiredCount = aws.Int64Value(service.DesiredCount)
+				target.RunningCount = aws.Int64Value(service.RunningCount)
+			}
+
+			if cd != nil {
+				for k, v := range cd.DockerLabels {
+					target.Labels[k] = *v
+				}
 			}
 
 			if s[group] == nil {
@@ -77,153 +188,209 @@ func (e *ECSCluster) GetTargets() (s Targets, err error) {
 		}
 	}
 
+	if len(partial) > 0 {
+		glog.Error(partial)
+		return s, partial
+	}
+
 	return s, nil
 }
 
-func (e *ECSCluster) getTasks() ([]*ecs.Task, error) {
+//getTaskDefinition returns the task definition for an arn, task definitions are immutable so they are cached indefinitely
+func (e *ECSCluster) getTaskDefinition(ctx context.Context, arn string) (*ecs.TaskDefinition, error) {
 
-	tasks := []*ecs.Task{}
-
-	input := &ecs.ListTasksInput{
-		Cluster: &e.Cluster,
+	if td, found := e.taskDefinitions[arn]; found {
+		return td, nil
 	}
 
-	err := e.ECSClient.ListTasksPages(input, func(taskArns *ecs.ListTasksOutput, lastPage bool) bool {
-
-		descrTasks, err := e.ECSClient.DescribeTasks(&ecs.DescribeTasksInput{
-			Cluster: &e.Cluster,
-			Tasks:   taskArns.TaskArns,
-		})
-
-		if err != nil {
-			glog.Error(err)
-			return false
-		}
-
-		if len(descrTasks.Failures) != 0 {
-			glog.Errorf("Failure describing task: %v - %v", *descrTasks.Failures[0].Arn, *descrTasks.Failures[0].Reason)
-			return false
-		}
-
-		tasks = append(tasks, descrTasks.Tasks...)
-
-		return !lastPage
-	})
+	o, err := e.ECSClient.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{TaskDefinition: &arn})
 
 	if err != nil {
-		glog.Error(err)
 		return nil, err
 	}
 
-	h, err := hashstructure.Hash(tasks, nil)
+	if e.taskDefinitions == nil {
+		e.taskDefinitions = map[string]*ecs.TaskDefinition{}
+	}
 
-	if err != nil {
-		glog.Error(err)
+	e.taskDefinitions[arn] = o.TaskDefinition
+
+	return o.TaskDefinition, nil
+}
+
+func containerDefinition(td *ecs.TaskDefinition, name string) *ecs.ContainerDefinition {
+	for _, cd := range td.ContainerDefinitions {
+		if cd.Name != nil && *cd.Name == name {
+			return cd
+		}
 	}
 
-	glog.V(1).Infof("last hash %d, new hash %d", e.tasksHash, h)
+	return nil
+}
 
-	if h == e.tasksHash && (*e).tasks != nil {
-		glog.Info("tasks haven't changed, hash is the same, returning cache")
-		return *e.tasks, nil
+//portName returns the name of the port mapping matching the network binding
+func portName(cd *ecs.ContainerDefinition, b *ecs.NetworkBinding) string {
+	if cd == nil || b.ContainerPort == nil {
+		return ""
 	}
 
-	e.tasksHash = h
-	e.tasks = &tasks
+	for _, p := range cd.PortMappings {
+		if p.Name != nil && p.ContainerPort != nil && *p.ContainerPort == *b.ContainerPort {
+			return *p.Name
+		}
+	}
 
-	return *e.tasks, err
+	return ""
 }
 
 //ECSApi contains the functions necessary to interact with ECS
 type ECSApi interface {
-	DescribeContainerInstances(*ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error)
-	ListTasksPages(*ecs.ListTasksInput, func(*ecs.ListTasksOutput, bool) bool) error
-	ListContainerInstancesPages(*ecs.ListContainerInstancesInput, func(*ecs.ListContainerInstancesOutput, bool) bool) error
-	DescribeTasks(*ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error)
+	DescribeContainerInstancesWithContext(aws.Context, *ecs.DescribeContainerInstancesInput, ...request.Option) (*ecs.DescribeContainerInstancesOutput, error)
+	ListTasksPagesWithContext(aws.Context, *ecs.ListTasksInput, func(*ecs.ListTasksOutput, bool) bool, ...request.Option) error
+	ListContainerInstancesPagesWithContext(aws.Context, *ecs.ListContainerInstancesInput, func(*ecs.ListContainerInstancesOutput, bool) bool, ...request.Option) error
+	DescribeTasksWithContext(aws.Context, *ecs.DescribeTasksInput, ...request.Option) (*ecs.DescribeTasksOutput, error)
+	DescribeTaskDefinitionWithContext(aws.Context, *ecs.DescribeTaskDefinitionInput, ...request.Option) (*ecs.DescribeTaskDefinitionOutput, error)
+	ListServicesPagesWithContext(aws.Context, *ecs.ListServicesInput, func(*ecs.ListServicesOutput, bool) bool, ...request.Option) error
+	DescribeServicesWithContext(aws.Context, *ecs.DescribeServicesInput, ...request.Option) (*ecs.DescribeServicesOutput, error)
 }
 
 //EC2Api contains the function necessary to interact with EC2
 type EC2Api interface {
-	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+	DescribeInstancesPagesWithContext(aws.Context, *ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool, ...request.Option) error
 }
 
 //ECSCluster holds the internal state of an ECS Cluster to retrieve scrape targets
 type ECSCluster struct {
-	Region, Cluster      string
-	ECSClient            ECSApi
-	EC2Client            EC2Api
-	hosts                *map[string]*ecsHost
-	tasks                *[]*ecs.Task
-	hostsHash, tasksHash uint64
+	Region, Cluster string
+	ECSClient       ECSApi
+	EC2Client       EC2Api
+	//HostTTL is how long a described container instance is cached before it is described again, DefaultHostTTL when zero
+	HostTTL time.Duration
+	//TaskRefresh is how often every task is described again to catch changes, DefaultTaskRefresh when zero
+	TaskRefresh time.Duration
+	//DescribeConcurrency bounds the describe calls made at once, DefaultDescribeConcurrency when zero
+	DescribeConcurrency int
+	//RequireServices fails discovery when services can't be looked up, as when targets are filtered or named by service tags.
+	//Otherwise targets are discovered without service metadata
+	RequireServices bool
+
+	hosts           map[string]*ecsHost
+	servicesDenied  bool
+	tasks           map[string]*ecs.Task
+	tasksRefreshed  time.Time
+	taskDefinitions map[string]*ecs.TaskDefinition
+	now             func() time.Time
 }
 
-//ecsHost stores metadata about ECS Container Hosts
-type ecsHost struct {
-	InstanceID           *string
-	ContainerInstanceArn *string
-	PrivateIPAddress     *string
+//VPC is a VPC the instances of a cluster run in, along with the region of the cluster
+type VPC struct {
+	ID, Region string
 }
 
-func (e *ECSCluster) getHosts() (map[string]*ecsHost, error) {
+//VPCs returns the VPCs the cluster instances run in, sorted by id
+func (e *ECSCluster) VPCs(ctx context.Context) ([]VPC, error) {
+
+	hosts, err := e.getHosts(ctx)
 
-	ec2InstanceIds := []*string{}
-	ec2InstanceIdsForCache := []string{}
-	instances := &map[string]*ecsHost{}
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	vpcs := []VPC{}
+
+	for _, h := range hosts {
+		if h.VpcID == nil || seen[*h.VpcID] {
+			continue
+		}
+
+		seen[*h.VpcID] = true
+		vpcs = append(vpcs, VPC{ID: *h.VpcID, Region: e.Region})
+	}
+
+	sortVPCs(vpcs)
+
+	return vpcs, nil
+}
 
-	e.ECSClient.ListContainerInstancesPages(&ecs.ListContainerInstancesInput{Cluster: &e.Cluster},
-		func(o *ecs.ListContainerInstancesOutput, lastPage bool) bool {
+//Clusters combines the targets of several ECS clusters, targets of the same group and container in different clusters share a record
+type Clusters []*ECSCluster
 
-			i, err := e.ECSClient.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{Cluster: &e.Cluster, ContainerInstances: o.ContainerInstanceArns})
+//GetTargets merges the targets of every cluster, failing if any cluster fails.
+//The tasks skipped in every cluster are returned as DiscoveryErrors along with the merged targets
+func (c Clusters) GetTargets(ctx context.Context) (Targets, error) {
+	s := make(Targets)
+	var partial DiscoveryErrors
 
-			if err != nil {
-				glog.Error(err)
+	for _, e := range c {
+		t, err := e.GetTargets(ctx)
+
+		if errs, ok := err.(DiscoveryErrors); ok {
+			partial = append(partial, errs...)
+		} else if err != nil {
+			return nil, err
+		}
+
+		for group, service := range t {
+			if s[group] == nil {
+				s[group] = make(map[string][]*Target)
 			}
 
-			for _, c := range i.ContainerInstances {
-				(*instances)[*c.Ec2InstanceId] = &ecsHost{InstanceID: c.Ec2InstanceId, ContainerInstanceArn: c.ContainerInstanceArn}
-				ec2InstanceIdsForCache = append(ec2InstanceIdsForCache, *c.Ec2InstanceId)
-				ec2InstanceIds = append(ec2InstanceIds, c.Ec2InstanceId)
+			for container, targets := range service {
+				s[group][container] = append(s[group][container], targets...)
 			}
+		}
+	}
 
-			return !lastPage
-		})
+	if len(partial) > 0 {
+		return s, partial
+	}
 
-	sort.Strings(ec2InstanceIdsForCache)
+	return s, nil
+}
 
-	h, err := hashstructure.Hash(ec2InstanceIds, nil)
+//VPCs returns the VPCs the instances of every cluster run in, each with the region of its cluster
+func (c Clusters) VPCs(ctx context.Context) ([]VPC, error) {
+	seen := map[VPC]bool{}
+	vpcs := []VPC{}
 
-	if err != nil {
-		glog.Error(err)
-	}
+	for _, e := range c {
+		v, err := e.VPCs(ctx)
 
-	glog.V(1).Info(h, ec2InstanceIds)
+		if err != nil {
+			return nil, err
+		}
 
-	if h == e.hostsHash && (*e).hosts != nil {
-		glog.Info("cluster instances haven't changed, hash is the same, returning cache")
-		return *e.hosts, nil
+		for _, vpc := range v {
+			if !seen[vpc] {
+				seen[vpc] = true
+				vpcs = append(vpcs, vpc)
+			}
+		}
 	}
 
-	e.hostsHash = h
+	sortVPCs(vpcs)
 
-	e.EC2Client.DescribeInstancesPages(&ec2.DescribeInstancesInput{InstanceIds: ec2InstanceIds},
-		func(o *ec2.DescribeInstancesOutput, lastPage bool) bool {
+	return vpcs, nil
+}
 
-			for _, r := range o.Reservations {
-				for _, i := range r.Instances {
-					(*instances)[*i.InstanceId].PrivateIPAddress = i.PrivateIpAddress
-				}
-			}
+func sortVPCs(vpcs []VPC) {
+	sort.Slice(vpcs, func(i, j int) bool {
+		if vpcs[i].ID != vpcs[j].ID {
+			return vpcs[i].ID < vpcs[j].ID
+		}
 
-			return !lastPage
-		})
+		return vpcs[i].Region < vpcs[j].Region
+	})
+}
 
-	if e.hosts == nil {
-		e.hosts = &map[string]*ecsHost{}
-	}
+//VPCIDs returns the ids of vpcs
+func VPCIDs(vpcs []VPC) []string {
+	ids := []string{}
 
-	for _, i := range *instances {
-		(*e.hosts)[*i.ContainerInstanceArn] = i
+	for _, v := range vpcs {
+		ids = append(ids, v.ID)
 	}
 
-	return *e.hosts, nil
+	return ids
 }
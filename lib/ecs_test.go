@@ -1,9 +1,16 @@
 package lib
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/stretchr/testify/assert"
@@ -11,14 +18,14 @@ import (
 
 type stubAWSClient struct{}
 
-func (*stubAWSClient) ListTasksPages(i *ecs.ListTasksInput, f func(*ecs.ListTasksOutput, bool) bool) error {
+func (*stubAWSClient) ListTasksPagesWithContext(ctx aws.Context, i *ecs.ListTasksInput, f func(*ecs.ListTasksOutput, bool) bool, opts ...request.Option) error {
 
-	f(&ecs.ListTasksOutput{TaskArns: []*string{aws.String("task1")}}, true)
+	f(&ecs.ListTasksOutput{TaskArns: []*string{aws.String("taskarn1")}}, true)
 
 	return nil
 }
 
-func (*stubAWSClient) DescribeContainerInstances(*ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error) {
+func (*stubAWSClient) DescribeContainerInstancesWithContext(aws.Context, *ecs.DescribeContainerInstancesInput, ...request.Option) (*ecs.DescribeContainerInstancesOutput, error) {
 	return &ecs.DescribeContainerInstancesOutput{
 		ContainerInstances: []*ecs.ContainerInstance{
 			&ecs.ContainerInstance{Ec2InstanceId: aws.String("i-1"), ContainerInstanceArn: aws.String("ci-arn1")},
@@ -28,19 +35,21 @@ func (*stubAWSClient) DescribeContainerInstances(*ecs.DescribeContainerInstances
 	}, nil
 }
 
-func (*stubAWSClient) ListContainerInstancesPages(i *ecs.ListContainerInstancesInput, f func(*ecs.ListContainerInstancesOutput, bool) bool) error {
+func (*stubAWSClient) ListContainerInstancesPagesWithContext(ctx aws.Context, i *ecs.ListContainerInstancesInput, f func(*ecs.ListContainerInstancesOutput, bool) bool, opts ...request.Option) error {
 
-	f(&ecs.ListContainerInstancesOutput{}, true)
+	f(&ecs.ListContainerInstancesOutput{ContainerInstanceArns: aws.StringSlice([]string{"ci-arn1", "ci-arn2", "ci-arn3"})}, true)
 
 	return nil
 }
 
-func (*stubAWSClient) DescribeTasks(*ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error) {
+func (*stubAWSClient) DescribeTasksWithContext(aws.Context, *ecs.DescribeTasksInput, ...request.Option) (*ecs.DescribeTasksOutput, error) {
 
 	return &ecs.DescribeTasksOutput{
 		Tasks: []*ecs.Task{
 			&ecs.Task{
 				TaskArn:              aws.String("taskarn1"),
+				LastStatus:           aws.String("RUNNING"),
+				TaskDefinitionArn:    aws.String("taskdef-arn1"),
 				ContainerInstanceArn: aws.String("ci-arn1"),
 				Group:                aws.String("family1:group1"),
 				Containers: []*ecs.Container{
@@ -58,7 +67,23 @@ func (*stubAWSClient) DescribeTasks(*ecs.DescribeTasksInput) (*ecs.DescribeTasks
 	}, nil
 }
 
-func (*stubAWSClient) DescribeInstancesPages(i *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool) error {
+func (*stubAWSClient) DescribeTaskDefinitionWithContext(aws.Context, *ecs.DescribeTaskDefinitionInput, ...request.Option) (*ecs.DescribeTaskDefinitionOutput, error) {
+
+	return &ecs.DescribeTaskDefinitionOutput{
+		TaskDefinition: &ecs.TaskDefinition{
+			Family:   aws.String("family1"),
+			Revision: aws.Int64(3),
+			ContainerDefinitions: []*ecs.ContainerDefinition{
+				&ecs.ContainerDefinition{
+					Name:         aws.String("container1"),
+					DockerLabels: map[string]*string{"team": aws.String("devops")},
+				},
+			},
+		},
+	}, nil
+}
+
+func (*stubAWSClient) DescribeInstancesPagesWithContext(ctx aws.Context, i *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
 	f(&ec2.DescribeInstancesOutput{
 		Reservations: []*ec2.Reservation{
 			&ec2.Reservation{
@@ -66,6 +91,7 @@ func (*stubAWSClient) DescribeInstancesPages(i *ec2.DescribeInstancesInput, f fu
 					&ec2.Instance{
 						InstanceId:       aws.String("i-1"),
 						PrivateIpAddress: aws.String("1.2.3.4"),
+						VpcId:            aws.String("vpc-1"),
 					},
 				},
 			},
@@ -75,11 +101,27 @@ func (*stubAWSClient) DescribeInstancesPages(i *ec2.DescribeInstancesInput, f fu
 	return nil
 }
 
+func (*stubAWSClient) ListServicesPagesWithContext(ctx aws.Context, i *ecs.ListServicesInput, f func(*ecs.ListServicesOutput, bool) bool, opts ...request.Option) error {
+
+	f(&ecs.ListServicesOutput{ServiceArns: []*string{aws.String("service-arn1")}}, true)
+
+	return nil
+}
+
+func (*stubAWSClient) DescribeServicesWithContext(aws.Context, *ecs.DescribeServicesInput, ...request.Option) (*ecs.DescribeServicesOutput, error) {
+
+	return &ecs.DescribeServicesOutput{
+		Services: []*ecs.Service{
+			&ecs.Service{ServiceName: aws.String("service1"), DesiredCount: aws.Int64(1), RunningCount: aws.Int64(1)},
+		},
+	}, nil
+}
+
 var ecsCluster = &ECSCluster{Region: "us-east-1", Cluster: "cluster1", ECSClient: &stubAWSClient{}, EC2Client: &stubAWSClient{}}
 
 func TestGetTasks(t *testing.T) {
 
-	tasks, err := ecsCluster.getTasks()
+	tasks, err := ecsCluster.getTasks(context.Background())
 
 	if err != nil {
 		t.Error(err)
@@ -93,7 +135,7 @@ func TestGetTargets(t *testing.T) {
 
 	var targets Targets
 
-	targets, err := ecsCluster.GetTargets()
+	targets, err := ecsCluster.GetTargets(context.Background())
 
 	if err != nil {
 		t.Error(err)
@@ -101,11 +143,13 @@ func TestGetTargets(t *testing.T) {
 	}
 
 	assert.Equal(t, targets["group1"]["container1"][0].Name, "container1")
+	assert.Equal(t, targets["group1"]["container1"][0].Family, "family1")
+	assert.Equal(t, targets["group1"]["container1"][0].Labels["team"], "devops")
 }
 
 func TestGetHosts(t *testing.T) {
 
-	h, err := ecsCluster.getHosts()
+	h, err := ecsCluster.getHosts(context.Background())
 
 	if err != nil {
 		t.Error(err)
@@ -113,3 +157,464 @@ func TestGetHosts(t *testing.T) {
 
 	assert.Equal(t, len(h), 3)
 }
+
+func TestVPCs(t *testing.T) {
+
+	vpcs, err := ecsCluster.VPCs(context.Background())
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Equal(t, []VPC{{ID: "vpc-1", Region: "us-east-1"}}, vpcs)
+}
+
+func TestClustersGetTargets(t *testing.T) {
+
+	c := Clusters{
+		&ECSCluster{Region: "us-east-1", Cluster: "cluster1", ECSClient: &stubAWSClient{}, EC2Client: &stubAWSClient{}},
+		&ECSCluster{Region: "us-west-2", Cluster: "cluster2", ECSClient: &stubAWSClient{}, EC2Client: &stubAWSClient{}},
+	}
+
+	targets, err := c.GetTargets(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, targets["group1"]["container1"], 2)
+	assert.Equal(t, "cluster1", targets["group1"]["container1"][0].Cluster)
+	assert.Equal(t, "cluster2", targets["group1"]["container1"][1].Cluster)
+
+	vpcs, err := c.VPCs(context.Background())
+
+	// vpcs carry the region of their cluster so private zones are associated in the right region
+	assert.NoError(t, err)
+	assert.Equal(t, []VPC{{ID: "vpc-1", Region: "us-east-1"}, {ID: "vpc-1", Region: "us-west-2"}}, vpcs)
+}
+
+//failingAWSClient fails the calls given an error and answers the others like stubAWSClient
+type failingAWSClient struct {
+	stubAWSClient
+	listContainerInstances, describeContainerInstances, describeTaskDefinition error
+}
+
+func (s *failingAWSClient) ListContainerInstancesPagesWithContext(ctx aws.Context, i *ecs.ListContainerInstancesInput, f func(*ecs.ListContainerInstancesOutput, bool) bool, opts ...request.Option) error {
+	if s.listContainerInstances != nil {
+		return s.listContainerInstances
+	}
+
+	return s.stubAWSClient.ListContainerInstancesPagesWithContext(ctx, i, f, opts...)
+}
+
+func (s *failingAWSClient) DescribeContainerInstancesWithContext(ctx aws.Context, i *ecs.DescribeContainerInstancesInput, opts ...request.Option) (*ecs.DescribeContainerInstancesOutput, error) {
+	if s.describeContainerInstances != nil {
+		return nil, s.describeContainerInstances
+	}
+
+	return s.stubAWSClient.DescribeContainerInstancesWithContext(ctx, i, opts...)
+}
+
+func (s *failingAWSClient) DescribeTaskDefinitionWithContext(ctx aws.Context, i *ecs.DescribeTaskDefinitionInput, opts ...request.Option) (*ecs.DescribeTaskDefinitionOutput, error) {
+	if s.describeTaskDefinition != nil {
+		return nil, s.describeTaskDefinition
+	}
+
+	return s.stubAWSClient.DescribeTaskDefinitionWithContext(ctx, i, opts...)
+}
+
+func TestGetTargetsErrors(t *testing.T) {
+
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+
+	c := &ECSCluster{Cluster: "cluster1", ECSClient: &failingAWSClient{listContainerInstances: throttled}, EC2Client: &stubAWSClient{}}
+
+	targets, err := c.GetTargets(context.Background())
+
+	var d *DiscoveryError
+
+	assert.Nil(t, targets)
+	assert.True(t, errors.As(err, &d))
+	assert.Equal(t, "ListContainerInstances", d.Op)
+	assert.Equal(t, "cluster1", d.Cluster)
+	assert.True(t, errors.Is(err, ErrThrottled))
+	assert.False(t, errors.Is(err, ErrPartialDiscovery))
+
+	// a failed describe used to leave a nil output to dereference
+	c = &ECSCluster{Cluster: "cluster1", ECSClient: &failingAWSClient{describeContainerInstances: errors.New("boom")}, EC2Client: &stubAWSClient{}}
+
+	targets, err = c.GetTargets(context.Background())
+
+	assert.Nil(t, targets)
+	assert.EqualError(t, err, "cluster cluster1: DescribeContainerInstances: boom")
+	assert.False(t, errors.Is(err, ErrThrottled))
+}
+
+func TestGetTargetsPartial(t *testing.T) {
+
+	c := Clusters{
+		&ECSCluster{Cluster: "cluster1", ECSClient: &failingAWSClient{describeTaskDefinition: errors.New("boom")}, EC2Client: &stubAWSClient{}},
+		&ECSCluster{Cluster: "cluster2", ECSClient: &stubAWSClient{}, EC2Client: &stubAWSClient{}},
+	}
+
+	targets, err := c.GetTargets(context.Background())
+
+	assert.True(t, errors.Is(err, ErrPartialDiscovery))
+	assert.Len(t, targets["group1"]["container1"], 1, "the targets of the tasks that resolved are returned")
+	assert.Equal(t, "cluster2", targets["group1"]["container1"][0].Cluster)
+
+	assert.Equal(t, DiscoveryErrors{
+		{Cluster: "cluster1", Op: "DescribeTaskDefinition", ARN: "taskdef-arn1", Group: "group1", Err: errors.New("boom")},
+	}, err)
+}
+
+//inventoryAWSClient lists the container instances in arns, each running on the EC2 instance named after it, and records what is described.
+//Describing an instance in terminated fails the whole DescribeInstances call, as EC2 does
+type inventoryAWSClient struct {
+	stubAWSClient
+	mu                 sync.Mutex
+	arns               []string
+	terminated         map[string]bool
+	describedArns      [][]string
+	describedInstances [][]string
+}
+
+func (s *inventoryAWSClient) ListContainerInstancesPagesWithContext(ctx aws.Context, i *ecs.ListContainerInstancesInput, f func(*ecs.ListContainerInstancesOutput, bool) bool, opts ...request.Option) error {
+	f(&ecs.ListContainerInstancesOutput{ContainerInstanceArns: aws.StringSlice(s.arns)}, true)
+
+	return nil
+}
+
+func (s *inventoryAWSClient) DescribeContainerInstancesWithContext(ctx aws.Context, i *ecs.DescribeContainerInstancesInput, opts ...request.Option) (*ecs.DescribeContainerInstancesOutput, error) {
+	o := &ecs.DescribeContainerInstancesOutput{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.describedArns = append(s.describedArns, aws.StringValueSlice(i.ContainerInstances))
+
+	for _, arn := range i.ContainerInstances {
+		o.ContainerInstances = append(o.ContainerInstances, &ecs.ContainerInstance{ContainerInstanceArn: arn, Ec2InstanceId: aws.String("i-" + *arn)})
+	}
+
+	return o, nil
+}
+
+func (s *inventoryAWSClient) DescribeInstancesPagesWithContext(ctx aws.Context, i *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
+	r := &ec2.Reservation{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.describedInstances = append(s.describedInstances, aws.StringValueSlice(i.InstanceIds))
+
+	for _, id := range i.InstanceIds {
+		if s.terminated[*id] {
+			return awserr.New(errCodeInstanceNotFound, fmt.Sprintf("The instance ID '%s' does not exist", *id), nil)
+		}
+	}
+
+	for _, id := range i.InstanceIds {
+		r.Instances = append(r.Instances, &ec2.Instance{InstanceId: id, PrivateIpAddress: aws.String("10.0.0.1"), VpcId: aws.String("vpc-1")})
+	}
+
+	f(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{r}}, true)
+
+	return nil
+}
+
+func TestGetHostsInventory(t *testing.T) {
+
+	ctx := context.Background()
+	c := &fakeClock{t: time.Unix(1000, 0)}
+	client := &inventoryAWSClient{arns: []string{"ci-1", "ci-2"}}
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client, EC2Client: client, HostTTL: time.Minute, now: c.now}
+
+	h, err := e.getHosts(ctx)
+
+	assert.NoError(t, err)
+	assert.Len(t, h, 2)
+	assert.Equal(t, "i-ci-1", *h["ci-1"].InstanceID)
+	assert.Equal(t, "10.0.0.1", *h["ci-1"].PrivateIPAddress)
+	assert.Equal(t, [][]string{{"ci-1", "ci-2"}}, client.describedArns)
+	assert.Equal(t, [][]string{{"i-ci-1", "i-ci-2"}}, client.describedInstances)
+
+	// only the new instance is described
+	client.arns = []string{"ci-1", "ci-2", "ci-3"}
+
+	h, err = e.getHosts(ctx)
+
+	assert.NoError(t, err)
+	assert.Len(t, h, 3)
+	assert.Equal(t, []string{"ci-3"}, client.describedArns[1])
+	assert.Equal(t, []string{"i-ci-3"}, client.describedInstances[1])
+
+	// deregistered instances are evicted, cached ones aren't described again
+	client.arns = []string{"ci-2", "ci-3"}
+
+	h, err = e.getHosts(ctx)
+
+	assert.NoError(t, err)
+	assert.Len(t, h, 2)
+	assert.NotContains(t, h, "ci-1")
+	assert.Len(t, client.describedArns, 2)
+
+	// instances described more than the ttl ago are described again
+	c.advance(2 * time.Minute)
+
+	_, err = e.getHosts(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ci-2", "ci-3"}, client.describedArns[2])
+}
+
+func TestGetHostsChunksDescribes(t *testing.T) {
+
+	client := &inventoryAWSClient{}
+
+	for i := 0; i < 250; i++ {
+		client.arns = append(client.arns, fmt.Sprintf("ci-%d", i))
+	}
+
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client, EC2Client: client}
+
+	h, err := e.getHosts(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, h, 250)
+	assert.ElementsMatch(t, []int{100, 100, 50}, chunkSizes(client.describedArns))
+}
+
+func TestGetHostsRetriesInstancesWithoutAddress(t *testing.T) {
+
+	// stubAWSClient only has an address for i-1, the other instances are described again on the next call
+	client := &stubAWSClient{}
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client, EC2Client: client}
+
+	e.getHosts(context.Background())
+
+	assert.False(t, e.hosts["ci-arn1"].described.IsZero())
+	assert.True(t, e.hosts["ci-arn2"].described.IsZero())
+}
+
+func TestGetHostsInstanceNotFound(t *testing.T) {
+
+	ctx := context.Background()
+	client := &inventoryAWSClient{arns: []string{"ci-1", "ci-2", "ci-3"}, terminated: map[string]bool{"i-ci-2": true}}
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client, EC2Client: client}
+
+	h, err := e.getHosts(ctx)
+
+	assert.NoError(t, err, "a terminated instance doesn't fail the discovery")
+	assert.Len(t, h, 3)
+	assert.Equal(t, "10.0.0.1", *h["ci-1"].PrivateIPAddress)
+	assert.Equal(t, "10.0.0.1", *h["ci-3"].PrivateIPAddress)
+	assert.Nil(t, h["ci-2"].PrivateIPAddress)
+	assert.True(t, h["ci-2"].notFound)
+	assert.Equal(t, [][]string{{"i-ci-1", "i-ci-2", "i-ci-3"}, {"i-ci-1"}, {"i-ci-2"}, {"i-ci-3"}}, client.describedInstances)
+
+	// the terminated instance is cached like the others until ECS deregisters it
+	_, err = e.getHosts(ctx)
+
+	assert.NoError(t, err)
+	assert.Len(t, client.describedInstances, 4)
+}
+
+//taskAWSClient lists the tasks in arns, describes them with the status and version in the maps and records what is described.
+//Tasks in failures are returned as failures with the reason, the most describe calls in flight at once is recorded in maxInFlight
+type taskAWSClient struct {
+	stubAWSClient
+	mu          sync.Mutex
+	arns        []string
+	pending     map[string]bool
+	versions    map[string]int64
+	failures    map[string]string
+	described   [][]string
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *taskAWSClient) ListTasksPagesWithContext(ctx aws.Context, i *ecs.ListTasksInput, f func(*ecs.ListTasksOutput, bool) bool, opts ...request.Option) error {
+	f(&ecs.ListTasksOutput{TaskArns: aws.StringSlice(s.arns)}, true)
+
+	return nil
+}
+
+func (s *taskAWSClient) DescribeTasksWithContext(ctx aws.Context, i *ecs.DescribeTasksInput, opts ...request.Option) (*ecs.DescribeTasksOutput, error) {
+	o := &ecs.DescribeTasksOutput{}
+
+	s.mu.Lock()
+	s.described = append(s.described, aws.StringValueSlice(i.Tasks))
+	s.inFlight++
+
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+
+	s.mu.Unlock()
+
+	// give the other describe calls a chance to start
+	time.Sleep(time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight--
+
+	for _, arn := range aws.StringValueSlice(i.Tasks) {
+		if reason, found := s.failures[arn]; found {
+			o.Failures = append(o.Failures, &ecs.Failure{Arn: aws.String(arn), Reason: aws.String(reason)})
+			continue
+		}
+
+		status := ecs.DesiredStatusRunning
+
+		if s.pending[arn] {
+			status = ecs.DesiredStatusPending
+		}
+
+		o.Tasks = append(o.Tasks, &ecs.Task{TaskArn: aws.String(arn), LastStatus: aws.String(status), Version: aws.Int64(s.versions[arn])})
+	}
+
+	return o, nil
+}
+
+func TestGetTasksCache(t *testing.T) {
+
+	ctx := context.Background()
+	c := &fakeClock{t: time.Unix(1000, 0)}
+	client := &taskAWSClient{arns: []string{"task-1", "task-2"}, pending: map[string]bool{"task-2": true}, versions: map[string]int64{}}
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client, TaskRefresh: 5 * time.Minute, now: c.now}
+
+	tasks, err := e.getTasks(ctx)
+
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, []string{"task-1", "task-2"}, client.described[0])
+
+	// only new tasks and those still pending are described
+	client.arns = []string{"task-1", "task-2", "task-3"}
+
+	tasks, err = e.getTasks(ctx)
+
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 3)
+	assert.Equal(t, []string{"task-2", "task-3"}, client.described[1])
+
+	// stopped tasks are evicted and the others come from the cache
+	client.arns = []string{"task-1", "task-3"}
+	delete(client.pending, "task-2")
+
+	tasks, err = e.getTasks(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "task-1", *tasks[0].TaskArn)
+	assert.Equal(t, "task-3", *tasks[1].TaskArn)
+	assert.Len(t, client.described, 2)
+	assert.NotContains(t, e.tasks, "task-2")
+
+	// a full refresh describes every task again and picks up changes
+	client.versions["task-1"] = 2
+	c.advance(5 * time.Minute)
+
+	tasks, err = e.getTasks(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"task-1", "task-3"}, client.described[2])
+	assert.Equal(t, int64(2), *tasks[0].Version)
+
+	_, err = e.getTasks(ctx)
+
+	assert.NoError(t, err)
+	assert.Len(t, client.described, 3)
+}
+
+func TestGetTasksChunksDescribes(t *testing.T) {
+
+	client := &taskAWSClient{}
+
+	for i := 0; i < 250; i++ {
+		client.arns = append(client.arns, fmt.Sprintf("task-%d", i))
+	}
+
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client}
+
+	tasks, err := e.getTasks(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 250)
+	assert.ElementsMatch(t, []int{100, 100, 50}, chunkSizes(client.described))
+}
+
+func TestGetTasksDescribeConcurrency(t *testing.T) {
+
+	client := &taskAWSClient{}
+
+	for i := 0; i < 500; i++ {
+		client.arns = append(client.arns, fmt.Sprintf("task-%d", i))
+	}
+
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client, DescribeConcurrency: 2}
+
+	tasks, err := e.getTasks(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 500)
+	assert.Len(t, client.described, 5)
+	assert.Equal(t, 2, client.maxInFlight)
+}
+
+func TestGetTasksFailures(t *testing.T) {
+
+	client := &taskAWSClient{
+		arns:     []string{"task-1", "task-2", "task-3"},
+		failures: map[string]string{"task-2": failureMissing, "task-3": "ACCESS_DENIED"},
+	}
+
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client}
+
+	tasks, err := e.getTasks(context.Background())
+
+	// a task that stopped since it was listed is left out, other failures skip the task as a partial discovery
+	assert.Len(t, tasks, 1)
+	assert.Equal(t, "task-1", *tasks[0].TaskArn)
+	assert.Equal(t, DiscoveryErrors{
+		{Cluster: "cluster1", Op: "DescribeTasks", ARN: "task-3", Err: errors.New("ACCESS_DENIED")},
+	}, err)
+	assert.NotContains(t, e.tasks, "task-2")
+	assert.NotContains(t, e.tasks, "task-3")
+
+	// a task described before is skipped with its group, so the records of the group can be left alone
+	client.failures = map[string]string{"task-1": "ACCESS_DENIED"}
+	e.tasks["task-1"].Group = aws.String("service:web")
+	e.tasksRefreshed = time.Time{}
+
+	_, err = e.getTasks(context.Background())
+
+	assert.Equal(t, DiscoveryErrors{
+		{Cluster: "cluster1", Op: "DescribeTasks", ARN: "task-1", Group: "web", Err: errors.New("ACCESS_DENIED")},
+	}, err)
+}
+
+func TestDescribeConcurrentlyStopsOnError(t *testing.T) {
+
+	e := &ECSCluster{Cluster: "cluster1", DescribeConcurrency: 1}
+
+	chunks := chunk(aws.StringSlice([]string{"a", "b", "c"}), 1)
+	calls := 0
+
+	err := e.describeConcurrently(context.Background(), chunks, func(ctx context.Context, i int, arns []*string) error {
+		calls++
+		return errors.New("boom")
+	})
+
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 1, calls)
+}
+
+func chunkSizes(chunks [][]string) []int {
+	sizes := []int{}
+
+	for _, c := range chunks {
+		sizes = append(sizes, len(c))
+	}
+
+	return sizes
+}
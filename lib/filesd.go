@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/mitchellh/hashstructure"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func init() {
+	RegisterSink("file_sd", func(c *Config) (DNS, error) {
+		if c.FileSDPath == "" {
+			return nil, fmt.Errorf("file-sd-path is required")
+		}
+
+		return &FileSD{Path: c.FileSDPath, Format: c.FileSDFormat}, nil
+	})
+}
+
+//FileSD writes targets to a Prometheus file_sd_configs file
+type FileSD struct {
+	Path string
+	//Format is json or yaml, when empty it is taken from the file extension
+	Format string
+	hash   uint64
+}
+
+//Sync writes the targets to the file when they have changed since the last write
+func (f *FileSD) Sync(ctx context.Context, targets Targets) (int, error) {
+	groups := TargetGroups(targets)
+
+	h, err := hashstructure.Hash(groups, nil)
+
+	if err != nil {
+		glog.Error(err)
+	}
+
+	if err == nil && h == f.hash {
+		glog.V(1).Infof("file_sd targets haven't changed, not rewriting %s", f.Path)
+		return 0, nil
+	}
+
+	if err := f.write(groups); err != nil {
+		return 0, err
+	}
+
+	f.hash = h
+
+	glog.Infof("Wrote %d target groups to %s", len(groups), f.Path)
+
+	return len(groups), nil
+}
+
+//Prune is a no-op, Sync rewrites the whole file
+func (f *FileSD) Prune(ctx context.Context, targets Targets) (int, error) {
+	return 0, nil
+}
+
+//RemoveAllManagedRecords empties the file
+func (f *FileSD) RemoveAllManagedRecords(ctx context.Context) (int, error) {
+	f.hash = 0
+
+	return 0, f.write([]*TargetGroup{})
+}
+
+func (f *FileSD) format() string {
+	if f.Format != "" {
+		return strings.ToLower(f.Format)
+	}
+
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".yml", ".yaml":
+		return "yaml"
+	}
+
+	return "json"
+}
+
+//write replaces the file atomically by writing a temporary file in the same directory and renaming it
+func (f *FileSD) write(groups []*TargetGroup) error {
+	var b []byte
+	var err error
+
+	switch f.format() {
+	case "json":
+		b, err = json.MarshalIndent(groups, "", "  ")
+	case "yaml":
+		b, err = yaml.Marshal(groups)
+	default:
+		err = fmt.Errorf("unknown file_sd format %q", f.Format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Path), "."+filepath.Base(f.Path))
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.Path)
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var fileSDTargets = Targets{
+	"group1": {
+		"container1": []*Target{
+			{Name: "container1", Group: "group1", IPAddress: "1.2.3.4", Port: 1234, TaskArn: "taskarn1"},
+		},
+	},
+}
+
+func TestFileSD(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "filesd")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	f := &FileSD{Path: filepath.Join(dir, "targets.json")}
+
+	n, err := f.Sync(context.Background(), fileSDTargets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	var groups []*TargetGroup
+
+	b, _ := ioutil.ReadFile(f.Path)
+
+	assert.Nil(t, json.Unmarshal(b, &groups))
+	assert.Equal(t, []string{"1.2.3.4:1234"}, groups[0].Targets)
+	assert.Equal(t, "container1", groups[0].Labels["__meta_ecs_container"])
+
+	n, err = f.Sync(context.Background(), fileSDTargets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "unchanged targets should not rewrite the file")
+
+	_, err = f.RemoveAllManagedRecords(context.Background())
+
+	assert.Nil(t, err)
+
+	b, _ = ioutil.ReadFile(f.Path)
+
+	assert.JSONEq(t, "[]", string(b))
+}
+
+func TestFileSDYAML(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "filesd")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	f := &FileSD{Path: filepath.Join(dir, "targets.yml")}
+
+	_, err = f.Sync(context.Background(), fileSDTargets)
+
+	assert.Nil(t, err)
+
+	var groups []*TargetGroup
+
+	b, _ := ioutil.ReadFile(f.Path)
+
+	assert.Nil(t, yaml.Unmarshal(b, &groups))
+	assert.Equal(t, []string{"1.2.3.4:1234"}, groups[0].Targets)
+
+	files, _ := ioutil.ReadDir(dir)
+
+	assert.Len(t, files, 1, "temporary files should be renamed or removed")
+}
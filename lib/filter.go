@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+//Filter selects the targets ecs-dns manages with shell glob patterns and service tags.
+//Patterns match the group name, or <group>/<container> when they contain a slash
+type Filter struct {
+	//Include keeps only matching targets when not empty
+	Include []string
+	//Exclude drops matching targets, even included ones
+	Exclude []string
+	//ServiceTags keeps only the targets of services tagged with every key=value when not empty
+	ServiceTags []string
+}
+
+//Validate returns an error for the first malformed pattern or service tag
+func (f *Filter) Validate() error {
+	for _, p := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("filter pattern %q: %v", p, err)
+		}
+	}
+
+	for _, t := range f.ServiceTags {
+		if k, _, found := strings.Cut(t, "="); !found || k == "" {
+			return fmt.Errorf("filter service tag %q: expected key=value", t)
+		}
+	}
+
+	return nil
+}
+
+//Match reports whether the targets of group and container are managed
+func (f *Filter) Match(group, container string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, group, container) {
+		return false
+	}
+
+	return !matchAny(f.Exclude, group, container)
+}
+
+//MatchTarget reports whether the service of the target carries every service tag of the filter
+func (f *Filter) MatchTarget(t *Target) bool {
+	for _, tag := range f.ServiceTags {
+		k, v, _ := strings.Cut(tag, "=")
+
+		if value, found := t.ServiceTags[k]; !found || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Apply returns the targets matched by the filter
+func (f *Filter) Apply(targets Targets) Targets {
+	if len(f.Include) == 0 && len(f.Exclude) == 0 && len(f.ServiceTags) == 0 {
+		return targets
+	}
+
+	s := make(Targets)
+
+	for group, service := range targets {
+		for container, t := range service {
+			if !f.Match(group, container) {
+				continue
+			}
+
+			matched := []*Target{}
+
+			for _, target := range t {
+				if f.MatchTarget(target) {
+					matched = append(matched, target)
+				}
+			}
+
+			if len(matched) == 0 {
+				continue
+			}
+
+			if s[group] == nil {
+				s[group] = make(map[string][]*Target)
+			}
+
+			s[group][container] = matched
+		}
+	}
+
+	return s
+}
+
+func matchAny(patterns []string, group, container string) bool {
+	for _, p := range patterns {
+		name := group
+
+		if strings.Contains(p, "/") {
+			name = group + "/" + container
+		}
+
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Filtered is a Backend returning only the targets matched by Filter
+type Filtered struct {
+	Backend Backend
+	Filter  Filter
+}
+
+//GetTargets returns the filtered targets of the backend
+func (f *Filtered) GetTargets(ctx context.Context) (Targets, error) {
+	t, err := f.Backend.GetTargets(ctx)
+
+	// a partial discovery still returns targets, they are filtered and returned with its error
+	if t == nil {
+		return nil, err
+	}
+
+	return f.Filter.Apply(t), err
+}
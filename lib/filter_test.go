@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+
+	targets := Targets{
+		"web-api":    {"app": {{Name: "app"}}, "sidecar": {{Name: "sidecar"}}},
+		"web-canary": {"app": {{Name: "app"}}},
+		"worker":     {"app": {{Name: "app"}}},
+	}
+
+	f := &Filter{Include: []string{"web-*"}, Exclude: []string{"*-canary", "*/sidecar"}}
+
+	assert.NoError(t, f.Validate())
+
+	filtered := f.Apply(targets)
+
+	assert.Len(t, filtered, 1)
+	assert.Len(t, filtered["web-api"], 1)
+	assert.NotNil(t, filtered["web-api"]["app"])
+
+	// an empty filter manages everything
+	assert.Equal(t, targets, (&Filter{}).Apply(targets))
+
+	assert.Error(t, (&Filter{Exclude: []string{"web-["}}).Validate())
+}
+
+func TestFilterServiceTags(t *testing.T) {
+
+	frontend := &Target{Name: "app", ServiceTags: map[string]string{"tier": "frontend", "team": "web"}}
+	backend := &Target{Name: "app", ServiceTags: map[string]string{"tier": "backend"}}
+	standalone := &Target{Name: "app"}
+
+	targets := Targets{
+		"web":    {"app": {frontend, backend}},
+		"worker": {"app": {backend}},
+		"batch":  {"app": {standalone}},
+	}
+
+	f := &Filter{ServiceTags: []string{"tier=frontend"}}
+
+	assert.NoError(t, f.Validate())
+
+	// only the targets of tagged services are kept, groups left without targets are dropped
+	assert.Equal(t, Targets{"web": {"app": {frontend}}}, f.Apply(targets))
+
+	assert.Error(t, (&Filter{ServiceTags: []string{"tier"}}).Validate())
+	assert.Error(t, (&Filter{ServiceTags: []string{"=frontend"}}).Validate())
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+//Health tracks the reconcile loop and AWS connectivity for health checks
+type Health struct {
+	Interval time.Duration
+	//Intervals is the number of intervals without a successful reconcile before the daemon is not ready
+	Intervals int
+
+	mu          sync.RWMutex
+	lastSuccess time.Time
+	lastErr     error
+	awsErr      error
+}
+
+//Reconciled records the result of a reconcile
+func (h *Health) Reconciled(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastErr = err
+
+	if err == nil {
+		h.lastSuccess = time.Now()
+	}
+}
+
+//SetInterval updates the reconcile interval and the intervals readiness allows without a successful reconcile, on reload
+func (h *Health) SetInterval(interval time.Duration, intervals int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.Interval = interval
+	h.Intervals = intervals
+}
+
+//Instrument tracks whether AWS is reachable from the requests made by clients created from the session
+func (h *Health) Instrument(s *session.Session) *session.Session {
+	s.Handlers.Complete.PushBack(func(r *request.Request) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.awsErr = nil
+
+		if aerr, ok := r.Error.(awserr.Error); ok && (aerr.Code() == request.ErrCodeRequestError || aerr.Code() == request.ErrCodeResponseTimeout) {
+			h.awsErr = aerr
+		}
+	})
+
+	return s
+}
+
+//Ready returns nil when a reconcile succeeded within the last Intervals intervals and AWS is reachable
+func (h *Health) Ready() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.awsErr != nil {
+		return fmt.Errorf("aws unreachable: %v", h.awsErr)
+	}
+
+	if h.lastSuccess.IsZero() {
+		return fmt.Errorf("no successful reconcile yet, last error: %v", h.lastErr)
+	}
+
+	if age := time.Since(h.lastSuccess); age > h.Interval*time.Duration(h.Intervals) {
+		return fmt.Errorf("last successful reconcile %s ago, last error: %v", age.Round(time.Second), h.lastErr)
+	}
+
+	return nil
+}
+
+//Healthz reports the process is alive
+func (h *Health) Healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+//Readyz reports whether the reconcile loop is keeping records up to date
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	if err := h.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
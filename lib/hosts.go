@@ -0,0 +1,210 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/golang/glog"
+)
+
+//DefaultHostTTL is how long a described container instance is cached when ECSCluster.HostTTL is zero
+const DefaultHostTTL = 10 * time.Minute
+
+//describeContainerInstancesLimit is the most container instances DescribeContainerInstances takes per call
+const describeContainerInstancesLimit = 100
+
+//describeInstancesLimit is the most instance ids passed to a DescribeInstances call
+const describeInstancesLimit = 1000
+
+//errCodeInstanceNotFound fails a DescribeInstances call when one of its instances doesn't exist, as after it was terminated
+const errCodeInstanceNotFound = "InvalidInstanceID.NotFound"
+
+//ecsHost stores metadata about ECS Container Hosts
+type ecsHost struct {
+	InstanceID           *string
+	ContainerInstanceArn *string
+	PrivateIPAddress     *string
+	VpcID                *string
+	//notFound is set when EC2 no longer knows the instance, its container instance is still listed until ECS deregisters it
+	notFound bool
+	//described is when the host was described, zero when it has to be described again on the next call
+	described time.Time
+}
+
+//getHosts returns the container instances of the cluster keyed by container instance arn.
+//The cluster is listed on every call, but only instances that are new or were described more than HostTTL ago are
+//described, and instances no longer listed are evicted
+func (e *ECSCluster) getHosts(ctx context.Context) (map[string]*ecsHost, error) {
+
+	now := clock(e.now)
+	ttl := e.HostTTL
+
+	if ttl <= 0 {
+		ttl = DefaultHostTTL
+	}
+
+	listed := map[string]bool{}
+	stale := []*string{}
+
+	err := e.ECSClient.ListContainerInstancesPagesWithContext(ctx, &ecs.ListContainerInstancesInput{Cluster: &e.Cluster},
+		func(o *ecs.ListContainerInstancesOutput, lastPage bool) bool {
+
+			for _, arn := range o.ContainerInstanceArns {
+				listed[*arn] = true
+
+				h, found := e.hosts[*arn]
+				hit := found && now.Sub(h.described) < ttl
+
+				recordCacheLookup("hosts", hit)
+
+				if !hit {
+					stale = append(stale, arn)
+				}
+			}
+
+			return !lastPage
+		})
+
+	if err != nil {
+		return nil, e.discoveryError("ListContainerInstances", "", err)
+	}
+
+	described, err := e.describeHosts(ctx, stale)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if e.hosts == nil {
+		e.hosts = map[string]*ecsHost{}
+	}
+
+	for arn := range e.hosts {
+		if !listed[arn] {
+			glog.V(1).Infof("evicting container instance %s of cluster %s", arn, e.Cluster)
+			delete(e.hosts, arn)
+		}
+	}
+
+	for _, h := range described {
+		// hosts EC2 didn't return an address for are described again on the next call, unless the instance is gone
+		if h.PrivateIPAddress != nil || h.notFound {
+			h.described = now
+		}
+
+		e.hosts[*h.ContainerInstanceArn] = h
+	}
+
+	glog.V(1).Infof("cluster %s has %d container instances, described %d", e.Cluster, len(e.hosts), len(described))
+
+	return e.hosts, nil
+}
+
+//describeHosts describes the container instances and the EC2 instances they run on
+func (e *ECSCluster) describeHosts(ctx context.Context, arns []*string) ([]*ecsHost, error) {
+
+	chunks := chunk(arns, describeContainerInstancesLimit)
+	outputs := make([]*ecs.DescribeContainerInstancesOutput, len(chunks))
+
+	err := e.describeConcurrently(ctx, chunks, func(ctx context.Context, i int, arns []*string) error {
+		o, err := e.ECSClient.DescribeContainerInstancesWithContext(ctx, &ecs.DescribeContainerInstancesInput{Cluster: &e.Cluster, ContainerInstances: arns})
+
+		outputs[i] = o
+
+		return err
+	})
+
+	if err != nil {
+		return nil, e.discoveryError("DescribeContainerInstances", "", err)
+	}
+
+	hosts := []*ecsHost{}
+	byInstanceID := map[string]*ecsHost{}
+	ids := []*string{}
+
+	for _, o := range outputs {
+		for _, c := range o.ContainerInstances {
+			if c.Ec2InstanceId == nil || c.ContainerInstanceArn == nil {
+				continue
+			}
+
+			h := &ecsHost{InstanceID: c.Ec2InstanceId, ContainerInstanceArn: c.ContainerInstanceArn}
+
+			hosts = append(hosts, h)
+			byInstanceID[*c.Ec2InstanceId] = h
+			ids = append(ids, c.Ec2InstanceId)
+		}
+
+		// the tasks of instances that failed to describe are reported as partial discovery by GetTargets
+		for _, f := range o.Failures {
+			if aws.StringValue(f.Reason) != failureMissing {
+				glog.Warningf("describing container instance %s of cluster %s: %s", aws.StringValue(f.Arn), e.Cluster, aws.StringValue(f.Reason))
+			}
+		}
+	}
+
+	var mu sync.Mutex
+
+	describe := func(ctx context.Context, ids []*string) error {
+		return e.EC2Client.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids},
+			func(o *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				mu.Lock()
+				defer mu.Unlock()
+
+				for _, r := range o.Reservations {
+					for _, i := range r.Instances {
+						if h, found := byInstanceID[aws.StringValue(i.InstanceId)]; found {
+							h.PrivateIPAddress = i.PrivateIpAddress
+							h.VpcID = i.VpcId
+						}
+					}
+				}
+
+				return !lastPage
+			})
+	}
+
+	err = e.describeConcurrently(ctx, chunk(ids, describeInstancesLimit), func(ctx context.Context, i int, ids []*string) error {
+		err := describe(ctx, ids)
+
+		if !isInstanceNotFound(err) {
+			return err
+		}
+
+		// a single missing instance fails the whole call, so the instances are described one at a time to find it.
+		// The tasks of missing instances are reported as partial discovery by GetTargets
+		for _, id := range ids {
+			err := describe(ctx, []*string{id})
+
+			if isInstanceNotFound(err) {
+				glog.Warningf("instance %s of a container instance of cluster %s doesn't exist", aws.StringValue(id), e.Cluster)
+
+				mu.Lock()
+				byInstanceID[aws.StringValue(id)].notFound = true
+				mu.Unlock()
+			} else if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, e.discoveryError("DescribeInstances", "", err)
+	}
+
+	return hosts, nil
+}
+
+func isInstanceNotFound(err error) bool {
+	var aerr awserr.Error
+
+	return errors.As(err, &aerr) && aerr.Code() == errCodeInstanceNotFound
+}
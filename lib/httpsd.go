@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+//TargetGroup is a Prometheus service discovery target group
+type TargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+//TargetGroups converts targets into Prometheus target groups, one per target so each carries its own labels
+func TargetGroups(targets Targets) []*TargetGroup {
+	groups := []*TargetGroup{}
+
+	for _, service := range targets {
+		for _, containers := range service {
+			for _, t := range containers {
+				groups = append(groups, &TargetGroup{
+					Targets: []string{fmt.Sprintf("%s:%d", t.IPAddress, t.Port)},
+					Labels: map[string]string{
+						"__meta_ecs_cluster":                  t.Cluster,
+						"__meta_ecs_group":                    t.Group,
+						"__meta_ecs_container":                t.Name,
+						"__meta_ecs_port_name":                t.PortName,
+						"__meta_ecs_task_arn":                 t.TaskArn,
+						"__meta_ecs_task_definition_revision": strconv.FormatInt(t.Revision, 10),
+						"__meta_ecs_availability_zone":        t.AvailabilityZone,
+						"__meta_ecs_instance_id":              t.InstanceID,
+						"__meta_ecs_service":                  t.Service,
+						"__meta_ecs_deployment_id":            t.DeploymentID,
+						"__meta_ecs_service_desired_count":    strconv.FormatInt(t.DesiredCount, 10),
+						"__meta_ecs_service_running_count":    strconv.FormatInt(t.RunningCount, 10),
+					},
+				})
+			}
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Labels["__meta_ecs_task_arn"] != groups[j].Labels["__meta_ecs_task_arn"] {
+			return groups[i].Labels["__meta_ecs_task_arn"] < groups[j].Labels["__meta_ecs_task_arn"]
+		}
+
+		return groups[i].Targets[0] < groups[j].Targets[0]
+	})
+
+	return groups
+}
+
+func init() {
+	RegisterSink("http_sd", func(c *Config) (DNS, error) {
+		return &HTTPSD{}, nil
+	})
+}
+
+//HTTPSD serves the latest targets as Prometheus HTTP service discovery JSON
+type HTTPSD struct {
+	mu     sync.RWMutex
+	groups []*TargetGroup
+}
+
+//Sync replaces the served target groups
+func (h *HTTPSD) Sync(ctx context.Context, targets Targets) (int, error) {
+	g := TargetGroups(targets)
+
+	h.mu.Lock()
+	h.groups = g
+	h.mu.Unlock()
+
+	return len(g), nil
+}
+
+//Prune is a no-op, Sync replaces all target groups
+func (h *HTTPSD) Prune(ctx context.Context, targets Targets) (int, error) {
+	return 0, nil
+}
+
+//RemoveAllManagedRecords stops serving any target groups
+func (h *HTTPSD) RemoveAllManagedRecords(ctx context.Context) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := len(h.groups)
+	h.groups = nil
+
+	return n, nil
+}
+
+func (h *HTTPSD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	g := h.groups
+	h.mu.RUnlock()
+
+	if g == nil {
+		g = []*TargetGroup{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(g); err != nil {
+		glog.Error(err)
+	}
+}
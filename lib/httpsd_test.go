@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSD(t *testing.T) {
+
+	h := &HTTPSD{}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/http_sd", nil))
+	assert.JSONEq(t, "[]", w.Body.String())
+
+	n, err := h.Sync(context.Background(), Targets{
+		"group1": {
+			"container1": []*Target{
+				{Name: "container1", Group: "group1", Cluster: "cluster1", IPAddress: "1.2.3.4", Port: 1234, TaskArn: "taskarn1", Revision: 3, AvailabilityZone: "us-east-1a", InstanceID: "i-1"},
+			},
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/http_sd", nil))
+
+	var groups []*TargetGroup
+
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &groups))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, []string{"1.2.3.4:1234"}, groups[0].Targets)
+	assert.Equal(t, "3", groups[0].Labels["__meta_ecs_task_definition_revision"])
+	assert.Equal(t, "us-east-1a", groups[0].Labels["__meta_ecs_availability_zone"])
+	assert.Equal(t, "i-1", groups[0].Labels["__meta_ecs_instance_id"])
+}
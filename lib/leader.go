@@ -0,0 +1,221 @@
+package lib
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/golang/glog"
+)
+
+//Lease is a lock held by a single daemon replica at a time, it expires unless renewed
+type Lease interface {
+	//Acquire takes or renews the lease for holder for ttl, it returns false while another holder has an unexpired lease
+	Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	//Release gives up the lease if holder has it
+	Release(ctx context.Context, holder string) error
+}
+
+//DynamoDBApi contains the functions necessary to hold a lease in DynamoDB
+type DynamoDBApi interface {
+	PutItemWithContext(aws.Context, *dynamodb.PutItemInput, ...request.Option) (*dynamodb.PutItemOutput, error)
+	DeleteItemWithContext(aws.Context, *dynamodb.DeleteItemInput, ...request.Option) (*dynamodb.DeleteItemOutput, error)
+}
+
+//DynamoDBLease holds a lease as an item of a table with a string "name" hash key, using conditional writes.
+//The expiry is written with the clock of the holder, so replica clocks are expected to be roughly in sync
+type DynamoDBLease struct {
+	Client DynamoDBApi
+	Table  string
+	Name   string
+	now    func() time.Time
+}
+
+//Acquire writes the lease unless another holder has it and it hasn't expired
+func (d *DynamoDBLease) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	now := clock(d.now)
+
+	_, err := d.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"name":    {S: aws.String(d.Name)},
+			"holder":  {S: aws.String(holder)},
+			"expires": {N: aws.String(strconv.FormatInt(now.Add(ttl).UnixNano()/int64(time.Millisecond), 10))},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#name) OR #holder = :holder OR #expires < :now"),
+		ExpressionAttributeNames: map[string]*string{"#name": aws.String("name"), "#holder": aws.String("holder"), "#expires": aws.String("expires")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(holder)},
+			":now":    {N: aws.String(strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10))},
+		},
+	})
+
+	if isConditionFailed(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+//Release deletes the lease if holder has it
+func (d *DynamoDBLease) Release(ctx context.Context, holder string) error {
+	_, err := d.Client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(d.Table),
+		Key:                       map[string]*dynamodb.AttributeValue{"name": {S: aws.String(d.Name)}},
+		ConditionExpression:       aws.String("#holder = :holder"),
+		ExpressionAttributeNames:  map[string]*string{"#holder": aws.String("holder")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":holder": {S: aws.String(holder)}},
+	})
+
+	if isConditionFailed(err) {
+		return nil
+	}
+
+	return err
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+//MemoryLease is a Lease shared by the electors of a single process, a stand-in for DynamoDBLease in tests and local runs
+type MemoryLease struct {
+	mu      sync.Mutex
+	holder  string
+	expires time.Time
+	now     func() time.Time
+}
+
+//Acquire takes the lease unless another holder has it and it hasn't expired
+func (m *MemoryLease) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := clock(m.now)
+
+	if m.holder != "" && m.holder != holder && now.Before(m.expires) {
+		return false, nil
+	}
+
+	m.holder = holder
+	m.expires = now.Add(ttl)
+
+	return true, nil
+}
+
+//Release gives up the lease if holder has it
+func (m *MemoryLease) Release(ctx context.Context, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.holder == holder {
+		m.holder = ""
+	}
+
+	return nil
+}
+
+//Elector campaigns for a Lease so only one daemon replica reconciles, the others follow and take over once it expires
+type Elector struct {
+	Lease  Lease
+	Holder string
+	//TTL is how long the lease lasts without renewal, it is renewed every third of it
+	TTL time.Duration
+
+	mu    sync.RWMutex
+	until time.Time
+	now   func() time.Time
+}
+
+//Campaign acquires or renews the lease once.
+//Leadership ends a third of the TTL before the lease expires, leaving room for an in-flight reconcile and clock skew,
+//and it is kept on errors until then so a DynamoDB blip doesn't stop reconciling
+func (e *Elector) Campaign(ctx context.Context) (bool, error) {
+	start := clock(e.now)
+
+	ok, err := e.Lease.Acquire(ctx, e.Holder, e.TTL)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	was := start.Before(e.until)
+
+	switch {
+	case err != nil:
+		return was, err
+	case ok:
+		e.until = start.Add(e.TTL - e.TTL/3)
+	default:
+		e.until = time.Time{}
+	}
+
+	if ok != was {
+		leaderChanges.Inc()
+		glog.Infof("%s leading: %t", e.Holder, ok)
+	}
+
+	if ok {
+		isLeader.Set(1)
+	} else {
+		isLeader.Set(0)
+	}
+
+	return ok, nil
+}
+
+//IsLeader reports whether this replica holds the lease
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return clock(e.now).Before(e.until)
+}
+
+//Run campaigns every third of the TTL until ctx is done, then releases the lease so a follower takes over right away
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		if _, err := e.Campaign(ctx); err != nil {
+			glog.Errorf("renewing the lease: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) release() {
+	e.mu.Lock()
+	e.until = time.Time{}
+	e.mu.Unlock()
+
+	isLeader.Set(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.TTL/3)
+	defer cancel()
+
+	if err := e.Lease.Release(ctx, e.Holder); err != nil {
+		glog.Errorf("releasing the lease: %v", err)
+	}
+}
+
+func clock(now func() time.Time) time.Time {
+	if now != nil {
+		return now()
+	}
+
+	return time.Now()
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+type failingLease struct{}
+
+func (failingLease) Acquire(context.Context, string, time.Duration) (bool, error) {
+	return false, errors.New("unreachable")
+}
+
+func (failingLease) Release(context.Context, string) error { return nil }
+
+func TestElectorFailover(t *testing.T) {
+
+	ctx := context.Background()
+	c := &fakeClock{t: time.Unix(1000, 0)}
+	lease := &MemoryLease{now: c.now}
+
+	a := &Elector{Lease: lease, Holder: "a", TTL: 15 * time.Second, now: c.now}
+	b := &Elector{Lease: lease, Holder: "b", TTL: 15 * time.Second, now: c.now}
+
+	ok, err := a.Campaign(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = b.Campaign(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	// a stops renewing, it steps down before its lease expires so b never leads alongside it
+	c.advance(10 * time.Second)
+	assert.False(t, a.IsLeader())
+
+	ok, _ = b.Campaign(ctx)
+	assert.False(t, ok)
+
+	c.advance(6 * time.Second)
+
+	ok, _ = b.Campaign(ctx)
+	assert.True(t, ok)
+	assert.True(t, b.IsLeader())
+
+	ok, _ = a.Campaign(ctx)
+	assert.False(t, ok)
+
+	// releasing hands the lease over right away
+	b.release()
+	assert.False(t, b.IsLeader())
+
+	ok, _ = a.Campaign(ctx)
+	assert.True(t, ok)
+}
+
+func TestElectorKeepsLeadingOnErrors(t *testing.T) {
+
+	ctx := context.Background()
+	c := &fakeClock{t: time.Unix(1000, 0)}
+
+	e := &Elector{Lease: &MemoryLease{now: c.now}, Holder: "a", TTL: 15 * time.Second, now: c.now}
+	e.Campaign(ctx)
+
+	e.Lease = failingLease{}
+	c.advance(5 * time.Second)
+
+	ok, err := e.Campaign(ctx)
+	assert.Error(t, err)
+	assert.True(t, ok)
+
+	c.advance(5 * time.Second)
+	assert.False(t, e.IsLeader())
+}
+
+type stubDynamoDBClient struct {
+	put    *dynamodb.PutItemInput
+	putErr error
+}
+
+func (s *stubDynamoDBClient) PutItemWithContext(ctx aws.Context, i *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	s.put = i
+
+	return &dynamodb.PutItemOutput{}, s.putErr
+}
+
+func (s *stubDynamoDBClient) DeleteItemWithContext(aws.Context, *dynamodb.DeleteItemInput, ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "held by another replica", nil)
+}
+
+func TestDynamoDBLease(t *testing.T) {
+
+	ctx := context.Background()
+	c := &fakeClock{t: time.Unix(1000, 0)}
+	client := &stubDynamoDBClient{}
+	lease := &DynamoDBLease{Client: client, Table: "ecs-dns-leases", Name: "ecs-dns", now: c.now}
+
+	ok, err := lease.Acquire(ctx, "a", 15*time.Second)
+
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "ecs-dns", *client.put.Item["name"].S)
+	assert.Equal(t, "a", *client.put.Item["holder"].S)
+	assert.Equal(t, "1015000", *client.put.Item["expires"].N)
+	assert.Equal(t, "1000000", *client.put.ExpressionAttributeValues[":now"].N)
+
+	client.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "held by another replica", nil)
+
+	ok, err = lease.Acquire(ctx, "a", 15*time.Second)
+
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	client.putErr = awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "slow down", nil)
+
+	_, err = lease.Acquire(ctx, "a", 15*time.Second)
+
+	assert.Error(t, err)
+
+	// a lease held by another replica is left alone
+	assert.NoError(t, lease.Release(ctx, "a"))
+}
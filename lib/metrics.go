@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	reconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ecs_dns_reconcile_duration_seconds",
+		Help:    "Time taken to discover targets and sync records.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	targetsDiscovered = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ecs_dns_targets",
+		Help: "Targets discovered in the last reconcile by group.",
+	}, []string{"group"})
+
+	recordsChanged = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecs_dns_records_changed_total",
+		Help: "Records submitted to the DNS provider by action.",
+	}, []string{"action"})
+
+	awsRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecs_dns_aws_requests_total",
+		Help: "AWS API calls by service and operation.",
+	}, []string{"service", "operation"})
+
+	awsErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecs_dns_aws_request_errors_total",
+		Help: "AWS API calls that failed by service and operation.",
+	}, []string{"service", "operation"})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecs_dns_cache_lookups_total",
+		Help: "Discovery cache lookups by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	sinkRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecs_dns_sink_operations_total",
+		Help: "Sync, prune and remove operations by sink.",
+	}, []string{"sink", "operation"})
+
+	sinkErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ecs_dns_sink_errors_total",
+		Help: "Failed sync, prune and remove operations by sink.",
+	}, []string{"sink", "operation"})
+
+	pruneRefusals = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ecs_dns_prune_refusals_total",
+		Help: "Prunes refused for deleting more than max-delete-percent of the managed records.",
+	})
+
+	isLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ecs_dns_leader",
+		Help: "Whether this replica holds the leader lease.",
+	})
+
+	leaderChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ecs_dns_leader_changes_total",
+		Help: "Times this replica acquired or lost the leader lease.",
+	})
+
+	sinkUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ecs_dns_sink_up",
+		Help: "Whether the last operation of a sink succeeded.",
+	}, []string{"sink"})
+
+	//lastSuccessfulSync holds unix nanoseconds, it is written by the reconcile loop and read by scrapes
+	lastSuccessfulSync = time.Now().UnixNano()
+
+	lastSuccessfulSyncTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ecs_dns_last_successful_sync_timestamp_seconds",
+		Help: "Unix time of the last successful reconcile.",
+	})
+
+	lastSuccessfulSyncAge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ecs_dns_last_successful_sync_age_seconds",
+		Help: "Seconds since the last successful reconcile, or since start if none has succeeded.",
+	}, func() float64 { return time.Since(time.Unix(0, atomic.LoadInt64(&lastSuccessfulSync))).Seconds() })
+)
+
+func init() {
+	prometheus.MustRegister(
+		reconcileDuration,
+		targetsDiscovered,
+		recordsChanged,
+		awsRequests,
+		awsErrors,
+		cacheLookups,
+		sinkRuns,
+		sinkErrors,
+		sinkUp,
+		pruneRefusals,
+		isLeader,
+		leaderChanges,
+		lastSuccessfulSyncTimestamp,
+		lastSuccessfulSyncAge,
+	)
+}
+
+//InstrumentSession counts the AWS API calls and errors made by clients created from the session
+func InstrumentSession(s *session.Session) *session.Session {
+	s.Handlers.Complete.PushBack(func(r *request.Request) {
+		awsRequests.WithLabelValues(r.ClientInfo.ServiceName, r.Operation.Name).Inc()
+
+		if r.Error != nil {
+			awsErrors.WithLabelValues(r.ClientInfo.ServiceName, r.Operation.Name).Inc()
+		}
+	})
+
+	return s
+}
+
+//RecordTargets reports the number of targets discovered per group
+func RecordTargets(t Targets) {
+	targetsDiscovered.Reset()
+
+	for group, service := range t {
+		n := 0
+
+		for _, containers := range service {
+			n += len(containers)
+		}
+
+		targetsDiscovered.WithLabelValues(group).Set(float64(n))
+	}
+}
+
+//RecordReconcile observes the duration of a reconcile started at start, marking it successful when err is nil
+func RecordReconcile(start time.Time, err error) {
+	reconcileDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+
+	atomic.StoreInt64(&lastSuccessfulSync, now.UnixNano())
+	lastSuccessfulSyncTimestamp.Set(float64(now.Unix()))
+}
+
+func recordCacheLookup(cache string, hit bool) {
+	result := "miss"
+
+	if hit {
+		result = "hit"
+	}
+
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
@@ -0,0 +1,272 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+//DefaultNameTemplate produces the original <container>.<group>.<domain> record names
+const DefaultNameTemplate = "{{.Container}}.{{.Service}}.{{.Domain}}"
+
+//NameData holds the values available to a naming template, all but Labels, Tags and ServiceTags are made valid labels with label
+type NameData struct {
+	Cluster, Service, Family, Container, PortName, Domain string
+	Labels, Tags, ServiceTags                             map[string]string
+}
+
+//Naming renders record names for targets from a Go text/template
+type Naming struct {
+	Domain string
+	tmpl   *template.Template
+}
+
+//NewNaming parses the naming template, an empty template uses DefaultNameTemplate
+func NewNaming(text, domain string) (*Naming, error) {
+
+	if text == "" {
+		text = DefaultNameTemplate
+	}
+
+	t, err := template.New("name").Option("missingkey=zero").Funcs(template.FuncMap{
+		"lower":    strings.ToLower,
+		"upper":    strings.ToUpper,
+		"sanitize": SanitizeLabel,
+	}).Parse(text)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %v", err)
+	}
+
+	return &Naming{Domain: domain, tmpl: t}, nil
+}
+
+//Name renders the record name of a target and validates it against the hosted zone
+func (n *Naming) Name(t *Target) (string, error) {
+
+	var b bytes.Buffer
+
+	err := n.tmpl.Execute(&b, &NameData{
+		Cluster:     label(t.Cluster),
+		Service:     label(t.Group),
+		Family:      label(t.Family),
+		Container:   label(t.Name),
+		PortName:    label(t.PortName),
+		Domain:      n.Domain,
+		Labels:      t.Labels,
+		Tags:        t.Tags,
+		ServiceTags: t.ServiceTags,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSuffix(strings.TrimSpace(b.String()), ".")
+
+	if err := validateName(name, n.Domain); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+//NameCollision is a record name rendered for more than one group and container
+type NameCollision struct {
+	Name string
+	Keys []string
+}
+
+//NamingError reports the targets that could not be named, invalid names are keyed by group:container
+type NamingError struct {
+	Invalid    map[string]error
+	Collisions []NameCollision
+}
+
+func (e *NamingError) Error() string {
+	var m []string
+
+	for k, err := range e.Invalid {
+		m = append(m, fmt.Sprintf("%s: %v", k, err))
+	}
+
+	sort.Strings(m)
+
+	for _, c := range e.Collisions {
+		m = append(m, fmt.Sprintf("%s is claimed by %s", c.Name, strings.Join(c.Keys, ", ")))
+	}
+
+	return "naming failed: " + strings.Join(m, "; ")
+}
+
+//Names renders the record name of every group and container. Names claimed by more than one
+//group and container are left out along with invalid names and those its targets disagree on, and reported in a *NamingError
+func (n *Naming) Names(targets Targets) (map[string]map[string]string, error) {
+
+	claims := map[string][]string{}
+	names := map[string]map[string]string{}
+	e := &NamingError{Invalid: map[string]error{}}
+
+	for group, service := range targets {
+		for container, t := range service {
+
+			if len(t) == 0 {
+				continue
+			}
+
+			key := fmt.Sprintf("%s:%s", group, container)
+
+			name, err := n.agreed(t)
+
+			if err != nil {
+				e.Invalid[key] = err
+				continue
+			}
+
+			claims[strings.ToLower(name)] = append(claims[strings.ToLower(name)], key)
+
+			if names[group] == nil {
+				names[group] = map[string]string{}
+			}
+
+			names[group][container] = name
+		}
+	}
+
+	for name, keys := range claims {
+
+		if len(keys) == 1 {
+			continue
+		}
+
+		sort.Strings(keys)
+
+		e.Collisions = append(e.Collisions, NameCollision{Name: name, Keys: keys})
+
+		for _, k := range keys {
+			i := strings.Index(k, ":")
+			delete(names[k[:i]], k[i+1:])
+		}
+	}
+
+	sort.Slice(e.Collisions, func(i, j int) bool { return e.Collisions[i].Name < e.Collisions[j].Name })
+
+	if len(e.Invalid) == 0 && len(e.Collisions) == 0 {
+		return names, nil
+	}
+
+	return names, e
+}
+
+//label keeps s byte-identical when it is a valid label, so the names of earlier releases don't change, and sanitizes it otherwise
+func label(s string) string {
+	if isValidLabel(s) {
+		return s
+	}
+
+	return SanitizeLabel(s)
+}
+
+//agreed renders the name of every target, which must all agree so the name doesn't depend on the order targets are listed in,
+//as when a template uses fields that differ between the task definition revisions of a deployment or between clusters
+func (n *Naming) agreed(targets []*Target) (string, error) {
+
+	names := map[string]string{}
+
+	for _, t := range targets {
+		name, err := n.Name(t)
+
+		if err != nil {
+			return "", err
+		}
+
+		// names differing in case only are the same name, the first in order is kept whatever the listing order
+		if other, found := names[strings.ToLower(name)]; !found || name < other {
+			names[strings.ToLower(name)] = name
+		}
+	}
+
+	if len(names) == 1 {
+		for _, name := range names {
+			return name, nil
+		}
+	}
+
+	distinct := []string{}
+
+	for name := range names {
+		distinct = append(distinct, name)
+	}
+
+	sort.Strings(distinct)
+
+	return "", fmt.Errorf("targets are named differently: %s", strings.Join(distinct, ", "))
+}
+
+//SanitizeLabel normalizes s into a valid DNS label: lowercase letters, digits and hyphens.
+//Labels longer than 63 characters are truncated and suffixed with a hash of s so they remain distinct
+func SanitizeLabel(s string) string {
+
+	var b strings.Builder
+
+	for _, c := range strings.ToLower(s) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteRune('-')
+		}
+	}
+
+	l := strings.TrimRight(b.String(), "-")
+
+	if len(l) <= 63 {
+		return l
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(s))
+
+	return fmt.Sprintf("%s-%08x", strings.TrimRight(l[:54], "-"), h.Sum32())
+}
+
+func validateName(name, domain string) error {
+
+	if len(name) > 253 {
+		return fmt.Errorf("name %q is longer than 253 characters", name)
+	}
+
+	for _, l := range strings.Split(name, ".") {
+		if !isValidLabel(l) {
+			return fmt.Errorf("name %q contains an invalid label %q", name, l)
+		}
+	}
+
+	zone := strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	if !strings.HasSuffix(strings.ToLower(name), "."+zone) {
+		return fmt.Errorf("name %q is not within the hosted zone %s", name, zone)
+	}
+
+	return nil
+}
+
+//isValidLabel checks a label is 1-63 letters, digits, hyphens and underscores, not starting or ending with a hyphen.
+//Underscores are allowed for SRV style names such as _http._tcp and for the group and container names of earlier releases
+func isValidLabel(l string) bool {
+
+	if len(l) == 0 || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+		return false
+	}
+
+	for _, c := range l {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
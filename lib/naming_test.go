@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var namingTarget = &Target{
+	Name:     "container1",
+	Group:    "group1",
+	Cluster:  "cluster1",
+	Family:   "family1",
+	PortName: "metrics",
+	Labels:   map[string]string{"team": "devops"},
+	Tags:     map[string]string{"env": "production"},
+
+	Service:     "group1",
+	ServiceTags: map[string]string{"tier": "frontend"},
+}
+
+func TestDefaultNaming(t *testing.T) {
+
+	n, err := NewNaming("", "sandbox1.ecs")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := n.Name(namingTarget)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "container1.group1.sandbox1.ecs", name)
+
+	// valid labels are kept as they are, so the names of earlier releases don't change
+	name, err = n.Name(&Target{Name: "my_app", Group: "Web"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "my_app.Web.sandbox1.ecs", name)
+
+	name, err = n.Name(&Target{Name: "my.app", Group: "web"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "my-app.web.sandbox1.ecs", name)
+}
+
+func TestTemplateNaming(t *testing.T) {
+
+	n, err := NewNaming(`{{.PortName}}.{{index .Labels "team"}}-{{.Tags.env}}.{{.Cluster}}.{{.Domain}}.`, "sandbox1.ecs")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := n.Name(namingTarget)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "metrics.devops-production.cluster1.sandbox1.ecs", name)
+
+	n, err = NewNaming(`{{.Container}}.{{.ServiceTags.tier}}.{{.Domain}}`, "sandbox1.ecs")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err = n.Name(namingTarget)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "container1.frontend.sandbox1.ecs", name)
+}
+
+func TestNamingValidation(t *testing.T) {
+
+	for _, tmpl := range []string{
+		"{{.Container}}.example.com",
+		"{{.Container}}/{{.Service}}.{{.Domain}}",
+		"{{.Missing}}.{{.Domain}}",
+		"{{.Domain}}",
+		"-{{.Container}}.{{.Domain}}",
+	} {
+		n, err := NewNaming(tmpl, "sandbox1.ecs")
+
+		if err != nil {
+			continue
+		}
+
+		_, err = n.Name(namingTarget)
+
+		assert.NotNil(t, err, tmpl)
+	}
+}
+
+func TestSanitizeLabel(t *testing.T) {
+
+	assert.Equal(t, "my-app-v2", SanitizeLabel("My_App.v2"))
+	assert.Equal(t, "api", SanitizeLabel("--api--"))
+	assert.Equal(t, "", SanitizeLabel("___"))
+
+	long := SanitizeLabel("a-very-long-container-name-that-goes-well-beyond-the-sixty-three-character-limit")
+
+	assert.Len(t, long, 63)
+	assert.True(t, isValidLabel(long))
+	assert.NotEqual(t, long, SanitizeLabel("a-very-long-container-name-that-goes-well-beyond-the-sixty-three-character-limit2"))
+}
+
+func TestNameCollisions(t *testing.T) {
+
+	n, _ := NewNaming("", "sandbox1.ecs")
+
+	names, err := n.Names(Targets{
+		"group1": {
+			"my.app": []*Target{{Name: "my.app", Group: "group1"}},
+			"my-app": []*Target{{Name: "my-app", Group: "group1"}},
+			"my_app": []*Target{{Name: "my_app", Group: "group1"}},
+			"api":    []*Target{{Name: "api", Group: "group1"}},
+		},
+	})
+
+	assert.IsType(t, &NamingError{}, err)
+	assert.Equal(t, []NameCollision{{Name: "my-app.group1.sandbox1.ecs", Keys: []string{"group1:my-app", "group1:my.app"}}}, err.(*NamingError).Collisions)
+	assert.Equal(t, map[string]map[string]string{"group1": {"api": "api.group1.sandbox1.ecs", "my_app": "my_app.group1.sandbox1.ecs"}}, names)
+}
+
+func TestNamesDisagree(t *testing.T) {
+
+	n, _ := NewNaming("{{.Container}}.{{.Family}}.{{.Domain}}", "sandbox1.ecs")
+
+	// targets of a deployment run two task definition families, the name would depend on which is listed first
+	names, err := n.Names(Targets{
+		"group1": {
+			"api": []*Target{{Name: "api", Group: "group1", Family: "api-v2"}, {Name: "api", Group: "group1", Family: "api-v1"}},
+			"web": []*Target{{Name: "web", Group: "group1", Family: "web"}, {Name: "web", Group: "group1", Family: "WEB"}},
+		},
+	})
+
+	assert.IsType(t, &NamingError{}, err)
+	assert.EqualError(t, err.(*NamingError).Invalid["group1:api"], "targets are named differently: api.api-v1.sandbox1.ecs, api.api-v2.sandbox1.ecs")
+	assert.Equal(t, map[string]map[string]string{"group1": {"web": "web.WEB.sandbox1.ecs"}}, names)
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+//ownerPrefix marks the records and instances managed by ecs-dns
+const ownerPrefix = "managed"
+
+//hostsPrefix marks the host names of target addresses managed by ecs-dns, which parse never takes for a group and container
+const hostsPrefix = "managed-hosts"
+
+//Owner scopes the records managed by an ecs-dns instance to its instance name.
+//The empty Owner manages the legacy managed:<group>:<container> records, a named one managed:<instance>:<group>:<container>,
+//so instances sharing a zone never touch each other's records
+type Owner string
+
+//ValidateOwner checks an instance name can be stored in owner identifiers
+func ValidateOwner(instance string) error {
+	if strings.ContainsAny(instance, ": \t\n") {
+		return fmt.Errorf("instance %q can't contain colons or whitespace", instance)
+	}
+
+	return nil
+}
+
+//id identifies the group and container a managed record belongs to.
+//Route53 stores it as the set identifier, Cloud Map and Consul as an attribute and RFC 2136 zones as a TXT record
+func (o Owner) id(group, container string) string {
+	if o == "" {
+		return fmt.Sprintf("%s:%s:%s", ownerPrefix, group, container)
+	}
+
+	return fmt.Sprintf("%s:%s:%s:%s", ownerPrefix, o, group, container)
+}
+
+//hostsID identifies the host names of target addresses managed by o.
+//Several instances can share a host name, each marking it with its own identifier
+func (o Owner) hostsID() string {
+	if o == "" {
+		return hostsPrefix
+	}
+
+	return fmt.Sprintf("%s:%s", hostsPrefix, o)
+}
+
+//parse returns the group and container of a record identifier managed by o
+func (o Owner) parse(id string) (group, container string, ok bool) {
+	i := strings.Split(id, ":")
+
+	if len(i) == 3 && i[0] == ownerPrefix && o == "" {
+		return i[1], i[2], true
+	}
+
+	if len(i) == 4 && i[0] == ownerPrefix && o != "" && i[1] == string(o) {
+		return i[2], i[3], true
+	}
+
+	return "", "", false
+}
+
+//owns reports whether id identifies a record managed by o
+func (o Owner) owns(id string) bool {
+	_, _, ok := o.parse(id)
+
+	return ok
+}
+
+//isStale reports whether the record identified by id is managed by o and no longer has targets
+func (o Owner) isStale(id string, targets Targets) bool {
+	group, container, ok := o.parse(id)
+
+	if !ok {
+		return false
+	}
+
+	_, found := targets[group][container]
+
+	return !found
+}
+
+//isRenamed reports whether the record identified by id is managed by o and named other than its targets are now named, as
+//after a name template change. Targets missing from names, whose name failed to render, keep the records they have
+func (o Owner) isRenamed(id, name string, names map[string]map[string]string) bool {
+	group, container, ok := o.parse(id)
+
+	if !ok {
+		return false
+	}
+
+	rendered, found := names[group][container]
+
+	return found && normalizeName(rendered) != normalizeName(name)
+}
+
+//normalizeName compares names regardless of case and of the trailing dot
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwner(t *testing.T) {
+
+	legacy, preview := Owner(""), Owner("preview-42")
+
+	assert.Equal(t, "managed:group1:container1", legacy.id("group1", "container1"))
+	assert.Equal(t, "managed:preview-42:group1:container1", preview.id("group1", "container1"))
+
+	group, container, ok := preview.parse("managed:preview-42:group1:container1")
+
+	assert.True(t, ok)
+	assert.Equal(t, "group1", group)
+	assert.Equal(t, "container1", container)
+
+	// instances never own each other's records, nor the legacy ones
+	assert.True(t, legacy.owns("managed:group1:container1"))
+	assert.False(t, legacy.owns("managed:preview-42:group1:container1"))
+	assert.False(t, preview.owns("managed:group1:container1"))
+	assert.False(t, preview.owns("managed:preview-7:group1:container1"))
+	assert.False(t, preview.owns("unmanaged"))
+
+	targets := Targets{"group1": {"container1": {&Target{}}}}
+
+	assert.False(t, preview.isStale("managed:preview-42:group1:container1", targets))
+	assert.True(t, preview.isStale("managed:preview-42:group1:container2", targets))
+	assert.False(t, preview.isStale("managed:preview-7:group1:container2", targets))
+
+	// a record is renamed when its targets render to another name, not when their name failed to render
+	names := map[string]map[string]string{"group1": {"container1": "container1.group1.sandbox1.ecs"}}
+
+	assert.False(t, preview.isRenamed("managed:preview-42:group1:container1", "Container1.group1.sandbox1.ecs.", names))
+	assert.True(t, preview.isRenamed("managed:preview-42:group1:container1", "group1.container1.sandbox1.ecs.", names))
+	assert.False(t, preview.isRenamed("managed:preview-42:group1:container2", "group1.container2.sandbox1.ecs.", names))
+	assert.False(t, preview.isRenamed("managed:preview-7:group1:container1", "group1.container1.sandbox1.ecs.", names))
+
+	assert.NoError(t, ValidateOwner("preview-42"))
+	assert.Error(t, ValidateOwner("preview:42"))
+}
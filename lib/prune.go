@@ -0,0 +1,38 @@
+package lib
+
+import "fmt"
+
+//PruneGuard keeps a prune from wiping the zone when discovery comes back short.
+//It refuses to delete more than MaxDeletePercent of the managed records unless Force is set, the zero value allows any prune.
+//Prunes deleting at most MinDeleteCount records are always allowed, so small zones don't need --force to lose their last records
+type PruneGuard struct {
+	MaxDeletePercent float64
+	MinDeleteCount   int
+	Force            bool
+}
+
+//PruneLimitError is returned by Prune when it refused to delete more than the allowed share of the managed records
+type PruneLimitError struct {
+	Deletes, Managed int
+	MaxDeletePercent float64
+}
+
+func (e *PruneLimitError) Error() string {
+	return fmt.Sprintf("refusing to prune %d of %d managed records, more than max-delete-percent %g%%, run sync --force to prune them",
+		e.Deletes, e.Managed, e.MaxDeletePercent)
+}
+
+//Check returns a PruneLimitError when deleting deletes of managed records exceeds the limit
+func (g PruneGuard) Check(managed, deletes int) error {
+	if g.Force || g.MaxDeletePercent <= 0 || deletes <= g.MinDeleteCount {
+		return nil
+	}
+
+	if float64(deletes)*100 <= float64(managed)*g.MaxDeletePercent {
+		return nil
+	}
+
+	pruneRefusals.Inc()
+
+	return &PruneLimitError{Deletes: deletes, Managed: managed, MaxDeletePercent: g.MaxDeletePercent}
+}
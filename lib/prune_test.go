@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneGuard(t *testing.T) {
+
+	g := PruneGuard{MaxDeletePercent: 50}
+
+	assert.NoError(t, g.Check(10, 0))
+	assert.NoError(t, g.Check(10, 5))
+	assert.NoError(t, g.Check(0, 0))
+
+	err := g.Check(10, 6)
+
+	assert.IsType(t, &PruneLimitError{}, err)
+	assert.Equal(t, "refusing to prune 6 of 10 managed records, more than max-delete-percent 50%, run sync --force to prune them", err.Error())
+
+	// small prunes are allowed whatever their share
+	g.MinDeleteCount = 5
+
+	assert.NoError(t, g.Check(1, 1))
+	assert.NoError(t, g.Check(3, 2))
+	assert.NoError(t, g.Check(6, 5))
+	assert.IsType(t, &PruneLimitError{}, g.Check(6, 6))
+
+	g.Force = true
+
+	assert.NoError(t, g.Check(10, 10))
+
+	// the zero value, used when removing every record, allows any prune
+	assert.NoError(t, PruneGuard{}.Check(10, 10))
+}
@@ -0,0 +1,496 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+func init() {
+	RegisterSink("rfc2136", func(c *Config) (DNS, error) {
+		if c.RFC2136Server == "" {
+			return nil, fmt.Errorf("rfc2136-server is required")
+		}
+
+		n, err := NewNaming(c.NameTemplate, c.Domain)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &RFC2136{
+			Server:        c.RFC2136Server,
+			Zone:          c.Domain,
+			TSIGKeyName:   c.RFC2136TSIGKeyName,
+			TSIGSecret:    c.RFC2136TSIGSecret,
+			TSIGAlgorithm: c.RFC2136TSIGAlgorithm,
+			Naming:        n,
+			Owner:         Owner(c.Instance),
+			Guard:         c.pruneGuard(),
+		}, nil
+	})
+}
+
+//RFC2136 manages SRV records in an authoritative zone with RFC 2136 DNS UPDATE messages.
+//Managed names are marked with a TXT record holding the owner identifier, and the zone is read with AXFR.
+//SRV records target a host name per address, <ip-with-dashes>.<zone>, holding its A or AAAA record
+type RFC2136 struct {
+	Server string
+	Zone   string
+	//TSIGKeyName, TSIGSecret (base64) and TSIGAlgorithm authenticate updates and transfers, leave TSIGKeyName empty to disable
+	TSIGKeyName, TSIGSecret, TSIGAlgorithm string
+	Naming                                 *Naming
+	Owner                                  Owner
+	Guard                                  PruneGuard
+}
+
+//zoneRecord holds the managed SRV and TXT records of a name.
+//Host names have host set and count the instances marking them in owners, owner is empty when this one doesn't
+type zoneRecord struct {
+	owner  string
+	srv    []dns.RR
+	host   bool
+	owners int
+}
+
+//Prune removes managed names no longer registered with the backend, and those left under an old name
+func (r *RFC2136) Prune(ctx context.Context, targets Targets) (int, error) {
+
+	n, err := r.naming()
+
+	if err != nil {
+		return 0, err
+	}
+
+	// names that failed to render are reported by Sync, their records are kept
+	names, _ := n.Names(targets)
+	hosts := map[string]dns.RR{}
+
+	for _, service := range targets {
+		for _, ts := range service {
+			_, h := r.serviceRecords("", ts)
+
+			for host, rr := range h {
+				hosts[host] = rr
+			}
+		}
+	}
+
+	return r.remove(ctx, r.Guard, hosts,
+		func(fqdn, owner string) bool { return r.Owner.isStale(owner, targets) },
+		func(fqdn, owner string) bool { return r.Owner.isRenamed(owner, fqdn, names) })
+}
+
+//RemoveAllManagedRecords removes every managed name from the zone
+func (r *RFC2136) RemoveAllManagedRecords(ctx context.Context) (int, error) {
+	return r.remove(ctx, PruneGuard{}, nil, func(string, string) bool { return true }, func(string, string) bool { return false })
+}
+
+//naming renders the record names, with the default template unless Naming is set
+func (r *RFC2136) naming() (*Naming, error) {
+	if r.Naming != nil {
+		return r.Naming, nil
+	}
+
+	return NewNaming(DefaultNameTemplate, r.Zone)
+}
+
+//Sync replaces the SRV records of changed names, names owned by something else are left alone
+func (r *RFC2136) Sync(ctx context.Context, targets Targets) (int, error) {
+
+	n, err := r.naming()
+
+	if err != nil {
+		return 0, err
+	}
+
+	names, nameErr := n.Names(targets)
+
+	if nameErr != nil {
+		glog.Error(nameErr)
+	}
+
+	existing, all, err := r.records(ctx)
+
+	if err != nil {
+		glog.Error(err)
+		return 0, err
+	}
+
+	m := r.message()
+	changes := 0
+	hosts := map[string]dns.RR{}
+
+	for group, service := range names {
+		for container, name := range service {
+
+			fqdn := dns.Fqdn(strings.ToLower(name))
+			owner := r.Owner.id(group, container)
+			e, found := existing[fqdn]
+
+			if !found && all[fqdn] {
+				glog.Errorf("Skipping %s, the name exists and isn't managed", fqdn)
+				continue
+			}
+
+			if found && e.owner != owner {
+				glog.Errorf("Skipping %s, the name is managed by %s", fqdn, e.owner)
+				continue
+			}
+
+			srv, h := r.serviceRecords(fqdn, targets[group][container])
+
+			for host, rr := range h {
+				hosts[host] = rr
+			}
+
+			if found && equalRecords(e.srv, srv) {
+				continue
+			}
+
+			glog.Infof("Upserting record %s", fqdn)
+
+			m.RemoveRRset([]dns.RR{&dns.SRV{Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeSRV, Class: dns.ClassINET}}})
+			m.Insert(srv)
+
+			if !found {
+				m.Insert([]dns.RR{ownerRecord(fqdn, owner)})
+			}
+
+			changes++
+		}
+	}
+
+	// host names are counted as changes to submit, but not as names synced
+	if err := r.submit(ctx, m, changes+r.insertHosts(m, hosts, existing, all), "upsert"); err != nil {
+		return 0, err
+	}
+
+	return changes, nameErr
+}
+
+//insertHosts adds the host names SRV records target that this instance doesn't mark yet, returning how many it adds
+func (r *RFC2136) insertHosts(m *dns.Msg, hosts map[string]dns.RR, existing map[string]*zoneRecord, all map[string]bool) int {
+
+	changes := 0
+
+	for host, rr := range hosts {
+		e, found := existing[host]
+
+		switch {
+		case found && e.host && e.owner != "":
+			continue
+		case found && !e.host:
+			glog.Errorf("Skipping the host %s, the name is managed by %s", host, e.owner)
+			continue
+		case !found && all[host]:
+			glog.Errorf("Skipping the host %s, the name exists and isn't managed", host)
+			continue
+		}
+
+		// a host another instance marks already holds the address, the name is only marked as this one's too
+		if !found {
+			glog.Infof("Adding host %s", host)
+			m.Insert([]dns.RR{rr})
+		}
+
+		m.Insert([]dns.RR{ownerRecord(host, r.Owner.hostsID())})
+		changes++
+	}
+
+	return changes
+}
+
+//remove deletes the managed names that with their owner satisfy stale, unless guard refuses, and those satisfying renamed.
+//Renamed names belong to targets that were discovered, so the guard against a short discovery doesn't hold them back.
+//Host names are deleted once neither the SRV records left nor the hosts wanted target them
+func (r *RFC2136) remove(ctx context.Context, guard PruneGuard, wanted map[string]dns.RR, stale, renamed func(fqdn, owner string) bool) (int, error) {
+
+	existing, _, err := r.records(ctx)
+
+	if err != nil {
+		glog.Error(err)
+		return 0, err
+	}
+
+	glog.Infof("record sets found %d", len(existing))
+
+	removes, renames := []string{}, []string{}
+	managed := 0
+
+	for fqdn, e := range existing {
+		if e.host {
+			continue
+		}
+
+		managed++
+
+		if stale(fqdn, e.owner) {
+			removes = append(removes, fqdn)
+		} else if renamed(fqdn, e.owner) {
+			renames = append(renames, fqdn)
+		}
+	}
+
+	guardErr := guard.Check(managed, len(removes))
+
+	if guardErr != nil {
+		glog.Error(guardErr)
+		removes = nil
+	}
+
+	removes = append(removes, renames...)
+
+	m := r.message()
+	changes := 0
+
+	for _, fqdn := range removes {
+		e := existing[fqdn]
+
+		glog.Infof("Removing record %s", fqdn)
+
+		m.RemoveRRset([]dns.RR{&dns.SRV{Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeSRV, Class: dns.ClassINET}}})
+		m.Remove([]dns.RR{ownerRecord(fqdn, e.owner)})
+
+		changes++
+	}
+
+	if err := r.submit(ctx, m, changes+r.removeHosts(m, existing, removes, wanted), "delete"); err != nil {
+		return 0, err
+	}
+
+	return changes, guardErr
+}
+
+//removeHosts deletes the host names this instance marks that no SRV record left and no wanted host targets.
+//The address is only deleted along with the last instance marking the name
+func (r *RFC2136) removeHosts(m *dns.Msg, existing map[string]*zoneRecord, removes []string, wanted map[string]dns.RR) int {
+
+	removed := map[string]bool{}
+
+	for _, fqdn := range removes {
+		removed[fqdn] = true
+	}
+
+	targeted := map[string]bool{}
+
+	for fqdn, e := range existing {
+		if removed[fqdn] {
+			continue
+		}
+
+		for _, rr := range e.srv {
+			targeted[strings.ToLower(rr.(*dns.SRV).Target)] = true
+		}
+	}
+
+	changes := 0
+
+	for host, e := range existing {
+		if _, found := wanted[host]; !e.host || e.owner == "" || found || targeted[host] {
+			continue
+		}
+
+		glog.Infof("Removing host %s", host)
+
+		m.Remove([]dns.RR{ownerRecord(host, e.owner)})
+
+		if e.owners == 1 {
+			m.RemoveRRset([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: host, Rrtype: dns.TypeA, Class: dns.ClassINET}}})
+			m.RemoveRRset([]dns.RR{&dns.AAAA{Hdr: dns.RR_Header{Name: host, Rrtype: dns.TypeAAAA, Class: dns.ClassINET}}})
+		}
+
+		changes++
+	}
+
+	return changes
+}
+
+//records transfers the zone, returning the managed names and the set of all names
+func (r *RFC2136) records(ctx context.Context) (map[string]*zoneRecord, map[string]bool, error) {
+
+	m := new(dns.Msg)
+	m.SetAxfr(dns.Fqdn(r.Zone))
+
+	t := &dns.Transfer{}
+
+	// zone transfers don't take a context, so they are bounded by its deadline instead
+	if deadline, ok := ctx.Deadline(); ok {
+		t.DialTimeout, t.ReadTimeout = time.Until(deadline), time.Until(deadline)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if r.TSIGKeyName != "" {
+		t.TsigSecret = map[string]string{dns.Fqdn(r.TSIGKeyName): r.TSIGSecret}
+		m.SetTsig(dns.Fqdn(r.TSIGKeyName), r.algorithm(), 300, time.Now().Unix())
+	}
+
+	env, err := t.In(m, r.Server)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	managed := map[string]*zoneRecord{}
+	all := map[string]bool{}
+	srv := map[string][]dns.RR{}
+
+	for e := range env {
+		if e.Error != nil {
+			return nil, nil, fmt.Errorf("zone transfer of %s failed: %v", r.Zone, e.Error)
+		}
+
+		for _, rr := range e.RR {
+			name := strings.ToLower(rr.Header().Name)
+			all[name] = true
+
+			switch v := rr.(type) {
+			case *dns.SRV:
+				srv[name] = append(srv[name], v)
+			case *dns.TXT:
+				id := strings.Join(v.Txt, "")
+
+				switch {
+				case r.Owner.owns(id):
+					managed[name] = &zoneRecord{owner: id}
+				case id == hostsPrefix || strings.HasPrefix(id, hostsPrefix+":"):
+					if managed[name] == nil {
+						managed[name] = &zoneRecord{host: true}
+					}
+
+					managed[name].owners++
+
+					if id == r.Owner.hostsID() {
+						managed[name].owner = id
+					}
+				}
+			}
+		}
+	}
+
+	for name, z := range managed {
+		z.srv = srv[name]
+	}
+
+	return managed, all, nil
+}
+
+//serviceRecords returns the SRV records of fqdn and the address records of the host names they target, keyed by host name
+func (r *RFC2136) serviceRecords(fqdn string, targets []*Target) ([]dns.RR, map[string]dns.RR) {
+	rrs := []dns.RR{}
+	hosts := map[string]dns.RR{}
+	zone := dns.Fqdn(strings.ToLower(r.Zone))
+
+	for _, t := range targets {
+		target := dns.Fqdn(t.IPAddress)
+
+		// TTL=0 to avoid DNS caches
+		if host, rr := hostRecord(t.IPAddress, zone, 0); rr != nil {
+			target = host
+			hosts[host] = rr
+		}
+
+		rrs = append(rrs, &dns.SRV{
+			Hdr:      dns.RR_Header{Name: fqdn, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 0},
+			Priority: 1,
+			Weight:   1,
+			Port:     uint16(t.Port),
+			Target:   target,
+		})
+	}
+
+	return rrs, hosts
+}
+
+func (r *RFC2136) message() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(r.Zone))
+
+	return m
+}
+
+//submit sends the update message, all changes are applied by the server atomically
+func (r *RFC2136) submit(ctx context.Context, m *dns.Msg, changes int, action string) error {
+
+	if changes == 0 {
+		glog.Info("No changes to be made")
+		return nil
+	}
+
+	c := &dns.Client{Net: "tcp"}
+
+	if r.TSIGKeyName != "" {
+		c.TsigSecret = map[string]string{dns.Fqdn(r.TSIGKeyName): r.TSIGSecret}
+		m.SetTsig(dns.Fqdn(r.TSIGKeyName), r.algorithm(), 300, time.Now().Unix())
+	}
+
+	resp, _, err := c.ExchangeContext(ctx, m, r.Server)
+
+	if err != nil {
+		glog.Error(err)
+		return err
+	}
+
+	if resp.Rcode != dns.RcodeSuccess {
+		err = fmt.Errorf("update of %s refused: %s", r.Zone, dns.RcodeToString[resp.Rcode])
+		glog.Error(err)
+		return err
+	}
+
+	glog.Infof("Changed %d records", changes)
+
+	recordsChanged.WithLabelValues(action).Add(float64(changes))
+
+	return nil
+}
+
+func (r *RFC2136) algorithm() string {
+	if r.TSIGAlgorithm == "" {
+		return dns.HmacSHA256
+	}
+
+	return dns.Fqdn(r.TSIGAlgorithm)
+}
+
+func ownerRecord(fqdn, owner string) dns.RR {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+		Txt: []string{owner},
+	}
+}
+
+func equalRecords(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	key := func(rrs []dns.RR) []string {
+		k := []string{}
+
+		for _, rr := range rrs {
+			k = append(k, strings.ToLower(rr.String()))
+		}
+
+		sort.Strings(k)
+
+		return k
+	}
+
+	ka, kb := key(a), key(b)
+
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+
+	return true
+}
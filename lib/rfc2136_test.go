@@ -0,0 +1,256 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testTSIGKey    = "ecs-dns."
+	testTSIGSecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+)
+
+//stubZone is a minimal authoritative server supporting TSIG signed AXFR and UPDATE
+type stubZone struct {
+	mu  sync.Mutex
+	rrs []dns.RR
+}
+
+func (z *stubZone) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	if r.IsTsig() == nil || w.TsigStatus() != nil {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	if r.Opcode == dns.OpcodeUpdate {
+		for _, rr := range r.Ns {
+			h := rr.Header()
+
+			switch h.Class {
+			case dns.ClassANY:
+				z.remove(func(e dns.RR) bool { return e.Header().Name == h.Name && e.Header().Rrtype == h.Rrtype })
+			case dns.ClassNONE:
+				rr.Header().Class = dns.ClassINET
+				z.remove(func(e dns.RR) bool { return dns.IsDuplicate(e, rr) })
+			default:
+				z.rrs = append(z.rrs, rr)
+			}
+		}
+
+		m.SetTsig(testTSIGKey, dns.HmacSHA256, 300, int64(r.IsTsig().TimeSigned))
+		w.WriteMsg(m)
+		return
+	}
+
+	soa, _ := dns.NewRR("sandbox1.ecs. 3600 IN SOA ns.sandbox1.ecs. admin.sandbox1.ecs. 1 3600 600 86400 0")
+
+	ch := make(chan *dns.Envelope)
+	tr := new(dns.Transfer)
+
+	go func() {
+		ch <- &dns.Envelope{RR: append(append([]dns.RR{soa}, z.rrs...), soa)}
+		close(ch)
+	}()
+
+	tr.Out(w, r, ch)
+}
+
+func (z *stubZone) remove(match func(dns.RR) bool) {
+	kept := []dns.RR{}
+
+	for _, e := range z.rrs {
+		if !match(e) {
+			kept = append(kept, e)
+		}
+	}
+
+	z.rrs = kept
+}
+
+func (z *stubZone) count(name string, t uint16) int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	n := 0
+
+	for _, e := range z.rrs {
+		if e.Header().Name == name && e.Header().Rrtype == t {
+			n++
+		}
+	}
+
+	return n
+}
+
+func startStubZone(t *testing.T, z *stubZone) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &dns.Server{
+		Listener:   l,
+		Handler:    z,
+		TsigSecret: map[string]string{testTSIGKey: testTSIGSecret},
+		// the default accept func refuses UPDATE messages
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+
+	go s.ActivateAndServe()
+
+	t.Cleanup(func() { s.Shutdown() })
+
+	return l.Addr().String()
+}
+
+func rfc2136Targets(ips ...string) Targets {
+	t := Targets{"group1": {"container1": []*Target{}}}
+
+	for _, ip := range ips {
+		t["group1"]["container1"] = append(t["group1"]["container1"], &Target{Name: "container1", Group: "group1", IPAddress: ip, Port: 1234})
+	}
+
+	return t
+}
+
+func TestRFC2136(t *testing.T) {
+
+	unmanaged, _ := dns.NewRR("api.group1.sandbox1.ecs. 0 IN SRV 1 1 80 10.0.0.1.")
+	z := &stubZone{rrs: []dns.RR{unmanaged}}
+
+	r := &RFC2136{Server: startStubZone(t, z), Zone: "sandbox1.ecs", TSIGKeyName: "ecs-dns", TSIGSecret: testTSIGSecret}
+
+	targets := rfc2136Targets("1.2.3.4", "1.2.3.5")
+	targets["group1"]["api"] = []*Target{{Name: "api", Group: "group1", IPAddress: "1.2.3.6", Port: 80}}
+
+	n, err := r.Sync(context.Background(), targets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n, "unmanaged names are left alone")
+	assert.Equal(t, 2, z.count("container1.group1.sandbox1.ecs.", dns.TypeSRV))
+	assert.Equal(t, 1, z.count("container1.group1.sandbox1.ecs.", dns.TypeTXT))
+
+	// SRV records target a host name per address, holding its A record
+	assert.Equal(t, 1, z.count("1-2-3-4.sandbox1.ecs.", dns.TypeA))
+	assert.Equal(t, 1, z.count("1-2-3-4.sandbox1.ecs.", dns.TypeTXT))
+	assert.Equal(t, 0, z.count("1-2-3-6.sandbox1.ecs.", dns.TypeA), "skipped names add no host")
+
+	n, err = r.Sync(context.Background(), targets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "unchanged names shouldn't be updated")
+
+	n, err = r.Sync(context.Background(), rfc2136Targets("1.2.3.4"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, z.count("container1.group1.sandbox1.ecs.", dns.TypeSRV))
+
+	n, err = r.Prune(context.Background(), Targets{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 0, z.count("container1.group1.sandbox1.ecs.", dns.TypeSRV))
+	assert.Equal(t, 0, z.count("container1.group1.sandbox1.ecs.", dns.TypeTXT))
+	assert.Equal(t, 1, z.count("api.group1.sandbox1.ecs.", dns.TypeSRV))
+
+	// host names are removed once no SRV record left targets them
+	assert.Equal(t, 0, z.count("1-2-3-4.sandbox1.ecs.", dns.TypeA))
+	assert.Equal(t, 0, z.count("1-2-3-5.sandbox1.ecs.", dns.TypeTXT))
+}
+
+func TestRFC2136PrunesRenamed(t *testing.T) {
+
+	z := &stubZone{}
+	r := &RFC2136{Server: startStubZone(t, z), Zone: "sandbox1.ecs", TSIGKeyName: "ecs-dns", TSIGSecret: testTSIGSecret}
+
+	targets := rfc2136Targets("1.2.3.4")
+
+	_, err := r.Sync(context.Background(), targets)
+
+	assert.Nil(t, err)
+
+	// after a name template change the record under the old name is pruned, the targets are synced under the new one.
+	// Renamed records don't count against the guard, their targets were discovered
+	r.Guard = PruneGuard{MaxDeletePercent: 50}
+	r.Naming, err = NewNaming("{{.Service}}-{{.Container}}.{{.Domain}}", "sandbox1.ecs")
+
+	assert.Nil(t, err)
+
+	n, err := r.Prune(context.Background(), targets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 0, z.count("container1.group1.sandbox1.ecs.", dns.TypeSRV))
+	assert.Equal(t, 0, z.count("container1.group1.sandbox1.ecs.", dns.TypeTXT))
+
+	n, err = r.Sync(context.Background(), targets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, z.count("group1-container1.sandbox1.ecs.", dns.TypeSRV))
+
+	n, err = r.Prune(context.Background(), targets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestRFC2136SharedHosts(t *testing.T) {
+
+	z := &stubZone{}
+	addr := startStubZone(t, z)
+
+	legacy := &RFC2136{Server: addr, Zone: "sandbox1.ecs", TSIGKeyName: "ecs-dns", TSIGSecret: testTSIGSecret}
+	preview := &RFC2136{Server: addr, Zone: "sandbox1.ecs", TSIGKeyName: "ecs-dns", TSIGSecret: testTSIGSecret, Owner: "preview"}
+
+	targets := rfc2136Targets("1.2.3.4")
+
+	_, err := legacy.Sync(context.Background(), targets)
+
+	assert.Nil(t, err)
+
+	targets["group1"]["api"] = []*Target{{Name: "api", Group: "group1", IPAddress: "1.2.3.4", Port: 80}}
+	delete(targets["group1"], "container1")
+
+	_, err = preview.Sync(context.Background(), targets)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, z.count("1-2-3-4.sandbox1.ecs.", dns.TypeA))
+	assert.Equal(t, 2, z.count("1-2-3-4.sandbox1.ecs.", dns.TypeTXT))
+
+	// the address stays as long as an instance marks the host
+	_, err = legacy.RemoveAllManagedRecords(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, z.count("1-2-3-4.sandbox1.ecs.", dns.TypeA))
+	assert.Equal(t, 1, z.count("1-2-3-4.sandbox1.ecs.", dns.TypeTXT))
+
+	_, err = preview.RemoveAllManagedRecords(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, z.count("1-2-3-4.sandbox1.ecs.", dns.TypeA))
+	assert.Equal(t, 0, z.count("1-2-3-4.sandbox1.ecs.", dns.TypeTXT))
+}
+
+func TestRFC2136RequiresTSIG(t *testing.T) {
+
+	r := &RFC2136{Server: startStubZone(t, &stubZone{}), Zone: "sandbox1.ecs"}
+
+	_, err := r.Sync(context.Background(), rfc2136Targets("1.2.3.4"))
+
+	assert.NotNil(t, err)
+}
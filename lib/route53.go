@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -12,25 +13,40 @@ import (
 
 //DNS represent a DNS provider that manages the SRV records
 type DNS interface {
-	Sync(Targets) (int, error)
-	Prune(Targets) (int, error)
-	RemoveAllManagedRecords() (int, error)
+	Sync(context.Context, Targets) (int, error)
+	Prune(context.Context, Targets) (int, error)
+	RemoveAllManagedRecords(context.Context) (int, error)
+}
+
+func init() {
+	RegisterSink("route53", func(c *Config) (DNS, error) {
+		n, err := NewNaming(c.NameTemplate, c.Domain)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &Route53{Domain: c.Domain, HostedZoneID: c.Zone, Naming: n, Owner: Owner(c.Instance), Guard: c.pruneGuard()}, nil
+	})
 }
 
 //Route53 represents functionality for managing service discovery records within AWS Route53
 type Route53 struct {
 	Domain       string
 	HostedZoneID string
+	Naming       *Naming
+	Owner        Owner
+	Guard        PruneGuard
 }
 
-func (r *Route53) recordSets() ([]*route53.ResourceRecordSet, error) {
+func (r *Route53) recordSets(ctx context.Context) ([]*route53.ResourceRecordSet, error) {
 	sess, err := session.NewSession()
 
 	if err != nil {
 		glog.Fatal(err)
 	}
 
-	r53 := route53.New(sess)
+	r53 := route53.New(InstrumentSession(sess))
 
 	rrs := []*route53.ResourceRecordSet{}
 
@@ -39,10 +55,10 @@ func (r *Route53) recordSets() ([]*route53.ResourceRecordSet, error) {
 		MaxItems:     aws.String("100"),
 	}
 
-	r53.ListResourceRecordSetsPages(paramsList, func(output *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+	err = r53.ListResourceRecordSetsPagesWithContext(ctx, paramsList, func(output *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
 
 		for _, s := range output.ResourceRecordSets {
-			if isManagedResourceRecordSet(s) {
+			if r.isManaged(s) {
 				rrs = append(rrs, s)
 			}
 		}
@@ -50,68 +66,92 @@ func (r *Route53) recordSets() ([]*route53.ResourceRecordSet, error) {
 		return !lastPage
 	})
 
-	return rrs, nil
+	return rrs, err
 }
 
-//Prune removes managed records no longer registered with the backend
-func (r *Route53) Prune(targets Targets) (int, error) {
+//Prune removes managed records no longer registered with the backend, and those left under an old name
+func (r *Route53) Prune(ctx context.Context, targets Targets) (int, error) {
 
-	records, err := r.recordSets()
+	records, err := r.recordSets(ctx)
 
 	if err != nil {
 		glog.Error(err)
+		return 0, err
 	}
 
 	glog.Infof("record sets found %d", len(records))
 
-	var removes []*route53.ResourceRecordSet
+	n, err := r.naming()
 
-	for _, v := range records {
-		i := strings.Split(*v.SetIdentifier, ":")
+	if err != nil {
+		return 0, err
+	}
 
-		if len(i) != 3 {
-			continue
-		}
+	// names that failed to render are reported by Sync, their records are kept
+	names, _ := n.Names(targets)
 
-		_, found := targets[i[1]][i[2]]
+	var stale, renamed []*route53.ResourceRecordSet
 
-		if !found {
-			removes = append(removes, v)
+	for _, v := range records {
+		if r.Owner.isStale(*v.SetIdentifier, targets) {
+			stale = append(stale, v)
+		} else if r.Owner.isRenamed(*v.SetIdentifier, *v.Name, names) {
+			renamed = append(renamed, v)
 		}
 	}
 
-	i := r.markForDelete(removes)
+	// the guard protects against a short discovery, renamed records belong to targets that were discovered
+	guardErr := r.Guard.Check(len(records), len(stale))
+
+	if guardErr != nil {
+		glog.Error(guardErr)
+		stale = nil
+	}
+
+	i := r.markForDelete(append(stale, renamed...))
 
-	changes, err := r.submitChanges(i)
+	changes, err := r.submitChanges(ctx, i)
 
 	if err != nil {
 		glog.Error(err)
+		return changes, err
 	}
 
-	return changes, err
+	return changes, guardErr
 }
 
 //Sync upserts Traefik backends into AWS hosted zone as SVC records
-func (r *Route53) Sync(targets Targets) (int, error) {
+func (r *Route53) Sync(ctx context.Context, targets Targets) (int, error) {
 
-	s := r.createServiceRecords(targets)
+	s, nameErr := r.createServiceRecords(targets)
+
+	if s == nil {
+		return 0, nameErr
+	}
 
 	c := r.markForUpsert(s)
 
-	return r.submitChanges(c)
+	i, err := r.submitChanges(ctx, c)
+
+	if err != nil {
+		return i, err
+	}
+
+	return i, nameErr
 }
 
 //RemoveAllManagedRecords deletes all managed records from the AWS Hosted Zone
-func (r *Route53) RemoveAllManagedRecords() (int, error) {
-	removes, err := r.recordSets()
+func (r *Route53) RemoveAllManagedRecords(ctx context.Context) (int, error) {
+	removes, err := r.recordSets(ctx)
 
 	if err != nil {
 		glog.Error(err)
+		return 0, err
 	}
 
 	c := r.markForDelete(removes)
 
-	return r.submitChanges(c)
+	return r.submitChanges(ctx, c)
 }
 
 func (r *Route53) markForDelete(records []*route53.ResourceRecordSet) []*route53.Change {
@@ -142,7 +182,7 @@ func (r *Route53) markForUpsert(records []*route53.ResourceRecordSet) []*route53
 	return c
 }
 
-func (r *Route53) submitChanges(changes []*route53.Change) (int, error) {
+func (r *Route53) submitChanges(ctx context.Context, changes []*route53.Change) (int, error) {
 
 	if len(changes) == 0 {
 		glog.Info("No changes to be made")
@@ -155,9 +195,9 @@ func (r *Route53) submitChanges(changes []*route53.Change) (int, error) {
 		glog.Error(err)
 	}
 
-	r53 := route53.New(sess)
+	r53 := route53.New(InstrumentSession(sess))
 
-	_, err = r53.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+	_, err = r53.ChangeResourceRecordSetsWithContext(ctx, &route53.ChangeResourceRecordSetsInput{
 		ChangeBatch: &route53.ChangeBatch{
 			Comment: aws.String("Service Discovery Created Record"),
 			Changes: changes,
@@ -172,35 +212,61 @@ func (r *Route53) submitChanges(changes []*route53.Change) (int, error) {
 
 	glog.Infof("Changed %d records", len(changes))
 
+	for _, c := range changes {
+		recordsChanged.WithLabelValues(strings.ToLower(*c.Action)).Inc()
+	}
+
 	return len(changes), nil
 }
 
-func isManagedResourceRecordSet(rrs *route53.ResourceRecordSet) bool {
+//isManaged reports whether rrs is a SRV record set managed by r.Owner
+func (r *Route53) isManaged(rrs *route53.ResourceRecordSet) bool {
 	return rrs != nil &&
 		rrs.Type != nil &&
 		*rrs.Type == route53.RRTypeSrv &&
 		rrs.SetIdentifier != nil &&
-		strings.HasPrefix(*rrs.SetIdentifier, "managed:")
+		r.Owner.owns(*rrs.SetIdentifier)
 }
 
-func (r *Route53) createServiceRecords(targets Targets) []*route53.ResourceRecordSet {
+//naming renders the record names, with the default template unless Naming is set
+func (r *Route53) naming() (*Naming, error) {
+	if r.Naming != nil {
+		return r.Naming, nil
+	}
+
+	return NewNaming(DefaultNameTemplate, r.Domain)
+}
+
+func (r *Route53) createServiceRecords(targets Targets) ([]*route53.ResourceRecordSet, error) {
 	rrs := []*route53.ResourceRecordSet{}
 
-	for group, service := range targets {
+	n, err := r.naming()
+
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := n.Names(targets)
+
+	if err != nil {
+		glog.Error(err)
+	}
+
+	for group, service := range names {
 
-		for serviceName, containers := range service {
+		for serviceName, name := range service {
 
 			s := &route53.ResourceRecordSet{
-				Name: aws.String(fmt.Sprintf("%s.%s.%s", serviceName, group, r.Domain)),
+				Name: aws.String(name),
 				// It creates a SRV record with the name of the service
 				Type:          aws.String(route53.RRTypeSrv),
-				SetIdentifier: aws.String(fmt.Sprintf("managed:%s:%s", group, serviceName)),
+				SetIdentifier: aws.String(r.Owner.id(group, serviceName)),
 				// TTL=0 to avoid DNS caches
 				TTL:    aws.Int64(0),
 				Weight: aws.Int64(1),
 			}
 
-			for _, r := range containers {
+			for _, r := range targets[group][serviceName] {
 				s.ResourceRecords = append(s.ResourceRecords, &route53.ResourceRecord{Value: aws.String(formatTargetSvcRecord(r))})
 			}
 
@@ -209,7 +275,7 @@ func (r *Route53) createServiceRecords(targets Targets) []*route53.ResourceRecor
 
 	}
 
-	return rrs
+	return rrs, err
 }
 
 func formatTargetSvcRecord(t *Target) string {
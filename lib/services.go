@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/golang/glog"
+)
+
+//describeServicesLimit is the most services DescribeServices takes per call
+const describeServicesLimit = 10
+
+//serviceGroupPrefix prefixes the group of the tasks an ECS service started
+const serviceGroupPrefix = "service:"
+
+//getServices returns the services of the cluster keyed by name, the services ECS failed to describe are returned as DiscoveryErrors.
+//Services are listed and described on every call since their counts and deployments change while their tasks don't
+func (e *ECSCluster) getServices(ctx context.Context) (map[string]*ecs.Service, error) {
+
+	arns := []*string{}
+
+	err := e.ECSClient.ListServicesPagesWithContext(ctx, &ecs.ListServicesInput{Cluster: &e.Cluster}, func(o *ecs.ListServicesOutput, lastPage bool) bool {
+		arns = append(arns, o.ServiceArns...)
+
+		return !lastPage
+	})
+
+	if err != nil {
+		return nil, e.discoveryError("ListServices", "", err)
+	}
+
+	chunks := chunk(arns, describeServicesLimit)
+	outputs := make([]*ecs.DescribeServicesOutput, len(chunks))
+
+	err = e.describeConcurrently(ctx, chunks, func(ctx context.Context, i int, arns []*string) error {
+		o, err := e.ECSClient.DescribeServicesWithContext(ctx, &ecs.DescribeServicesInput{
+			Cluster:  &e.Cluster,
+			Services: arns,
+			Include:  []*string{aws.String(ecs.ServiceFieldTags)},
+		})
+
+		outputs[i] = o
+
+		return err
+	})
+
+	if err != nil {
+		return nil, e.discoveryError("DescribeServices", "", err)
+	}
+
+	services := map[string]*ecs.Service{}
+	var failures DiscoveryErrors
+
+	for _, o := range outputs {
+		for _, s := range o.Services {
+			services[aws.StringValue(s.ServiceName)] = s
+		}
+
+		for _, f := range o.Failures {
+			if aws.StringValue(f.Reason) == failureMissing {
+				glog.V(1).Infof("service %s of cluster %s was deleted since it was listed", aws.StringValue(f.Arn), e.Cluster)
+				continue
+			}
+
+			failures = append(failures, e.discoveryError("DescribeServices", aws.StringValue(f.Arn), errors.New(aws.StringValue(f.Reason))))
+		}
+	}
+
+	glog.V(1).Infof("cluster %s has %d services", e.Cluster, len(services))
+
+	if len(failures) > 0 {
+		return services, failures
+	}
+
+	return services, nil
+}
+
+//serviceMetadata decides on a failed service lookup. The targets of the tasks are discovered without service metadata and
+//the discovery is partial, unless services are required. Without permission to look services up it isn't an error at all,
+//so clusters whose policy predates service discovery keep working
+func (e *ECSCluster) serviceMetadata(services map[string]*ecs.Service, err error) (map[string]*ecs.Service, error) {
+
+	var d *DiscoveryError
+	_, partial := err.(DiscoveryErrors)
+
+	if err == nil {
+		return services, nil
+	} else if partial && e.RequireServices {
+		// the services that were described aren't enough, the targets of the others would be filtered or named differently
+		return nil, fmt.Errorf("service metadata is required: %v", err)
+	} else if partial {
+		return services, err
+	} else if e.RequireServices || !errors.As(err, &d) {
+		return nil, err
+	}
+
+	var aerr awserr.Error
+
+	if errors.As(err, &aerr) && aerr.Code() == ecs.ErrCodeAccessDeniedException {
+		if !e.servicesDenied {
+			glog.Warningf("cluster %s: discovering targets without service metadata: %v", e.Cluster, err)
+			e.servicesDenied = true
+		}
+
+		return nil, nil
+	}
+
+	return nil, DiscoveryErrors{d}
+}
+
+//serviceName returns the name of the service that started the task, empty for standalone tasks
+func serviceName(task *ecs.Task) string {
+	if g := aws.StringValue(task.Group); strings.HasPrefix(g, serviceGroupPrefix) {
+		return strings.TrimPrefix(g, serviceGroupPrefix)
+	}
+
+	return ""
+}
+
+//deploymentID returns the id of the service deployment that started the task, ECS starts the tasks of a deployment by its id
+func deploymentID(s *ecs.Service, task *ecs.Task) string {
+	for _, d := range s.Deployments {
+		if aws.StringValue(d.Id) == aws.StringValue(task.StartedBy) {
+			return aws.StringValue(d.Id)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+//serviceAWSClient runs the stub task as part of service web and lists the services in names, those in failures fail to describe.
+//Listing fails with listErr when set
+type serviceAWSClient struct {
+	stubAWSClient
+	mu        sync.Mutex
+	listErr   error
+	names     []string
+	failures  map[string]string
+	described [][]string
+}
+
+func (s *serviceAWSClient) DescribeTasksWithContext(ctx aws.Context, i *ecs.DescribeTasksInput, opts ...request.Option) (*ecs.DescribeTasksOutput, error) {
+	o, _ := s.stubAWSClient.DescribeTasksWithContext(ctx, i, opts...)
+
+	o.Tasks[0].Group = aws.String("service:web")
+	o.Tasks[0].StartedBy = aws.String("ecs-svc/2")
+
+	return o, nil
+}
+
+func (s *serviceAWSClient) ListServicesPagesWithContext(ctx aws.Context, i *ecs.ListServicesInput, f func(*ecs.ListServicesOutput, bool) bool, opts ...request.Option) error {
+	if s.listErr != nil {
+		return s.listErr
+	}
+
+	f(&ecs.ListServicesOutput{ServiceArns: aws.StringSlice(s.names)}, true)
+
+	return nil
+}
+
+func (s *serviceAWSClient) DescribeServicesWithContext(ctx aws.Context, i *ecs.DescribeServicesInput, opts ...request.Option) (*ecs.DescribeServicesOutput, error) {
+	o := &ecs.DescribeServicesOutput{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.described = append(s.described, aws.StringValueSlice(i.Services))
+
+	for _, name := range aws.StringValueSlice(i.Services) {
+		if reason, found := s.failures[name]; found {
+			o.Failures = append(o.Failures, &ecs.Failure{Arn: aws.String(name), Reason: aws.String(reason)})
+			continue
+		}
+
+		o.Services = append(o.Services, &ecs.Service{
+			ServiceName:  aws.String(name),
+			DesiredCount: aws.Int64(3),
+			RunningCount: aws.Int64(2),
+			Tags:         []*ecs.Tag{{Key: aws.String("team"), Value: aws.String("devops")}},
+			Deployments: []*ecs.Deployment{
+				{Id: aws.String("ecs-svc/1"), Status: aws.String("ACTIVE")},
+				{Id: aws.String("ecs-svc/2"), Status: aws.String("PRIMARY")},
+			},
+		})
+	}
+
+	return o, nil
+}
+
+func TestGetTargetsServices(t *testing.T) {
+
+	client := &serviceAWSClient{names: []string{"web", "worker"}}
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client, EC2Client: &stubAWSClient{}}
+
+	targets, err := e.GetTargets(context.Background())
+
+	assert.NoError(t, err)
+
+	target := targets["web"]["container1"][0]
+
+	assert.Equal(t, "web", target.Group)
+	assert.Equal(t, "web", target.Service)
+	assert.Equal(t, map[string]string{"team": "devops"}, target.ServiceTags)
+	assert.Equal(t, "ecs-svc/2", target.DeploymentID)
+	assert.Equal(t, int64(3), target.DesiredCount)
+	assert.Equal(t, int64(2), target.RunningCount)
+
+	// standalone tasks have no service
+	targets, err = ecsCluster.GetTargets(context.Background())
+
+	assert.NoError(t, err)
+	assert.Empty(t, targets["group1"]["container1"][0].Service)
+}
+
+func TestGetServicesChunksDescribes(t *testing.T) {
+
+	client := &serviceAWSClient{}
+
+	for i := 0; i < 25; i++ {
+		client.names = append(client.names, fmt.Sprintf("service-%d", i))
+	}
+
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client}
+
+	services, err := e.getServices(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, services, 25)
+	assert.ElementsMatch(t, []int{10, 10, 5}, chunkSizes(client.described))
+}
+
+func TestGetServicesFailures(t *testing.T) {
+
+	client := &serviceAWSClient{
+		names:    []string{"web", "deleted", "denied"},
+		failures: map[string]string{"deleted": failureMissing, "denied": "ACCESS_DENIED"},
+	}
+
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client, EC2Client: &stubAWSClient{}}
+
+	// a service deleted since it was listed is left out, other failures make the discovery partial
+	targets, err := e.GetTargets(context.Background())
+
+	assert.True(t, errors.Is(err, ErrPartialDiscovery))
+	assert.Equal(t, DiscoveryErrors{
+		{Cluster: "cluster1", Op: "DescribeServices", ARN: "denied", Err: errors.New("ACCESS_DENIED")},
+	}, err)
+	assert.Equal(t, "web", targets["web"]["container1"][0].Service)
+	assert.Contains(t, err.Error(), "DescribeServices failed for 1 service")
+
+	// unless services are required
+	e = &ECSCluster{Cluster: "cluster1", ECSClient: client, EC2Client: &stubAWSClient{}, RequireServices: true}
+
+	targets, err = e.GetTargets(context.Background())
+
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrPartialDiscovery))
+	assert.Nil(t, targets)
+}
+
+func TestGetServicesListFailure(t *testing.T) {
+
+	client := &serviceAWSClient{names: []string{"web"}, listErr: errors.New("unavailable")}
+	e := &ECSCluster{Cluster: "cluster1", ECSClient: client, EC2Client: &stubAWSClient{}}
+
+	// the targets are kept without service metadata, the discovery is partial but skipped no task
+	targets, err := e.GetTargets(context.Background())
+
+	assert.True(t, errors.Is(err, ErrPartialDiscovery))
+	assert.Contains(t, err.Error(), "ListServices failed, tasks have no service metadata")
+	assert.Equal(t, "web", targets["web"]["container1"][0].Group)
+	assert.Empty(t, targets["web"]["container1"][0].Service)
+
+	skipped, known := err.(DiscoveryErrors).Skipped()
+
+	assert.True(t, known)
+	assert.Empty(t, skipped)
+
+	// without permission to list services it isn't an error
+	client.listErr = awserr.New(ecs.ErrCodeAccessDeniedException, "not authorized", nil)
+
+	targets, err = e.GetTargets(context.Background())
+
+	assert.NoError(t, err)
+	assert.Empty(t, targets["web"]["container1"][0].Service)
+
+	// unless services are required
+	e.RequireServices = true
+
+	targets, err = e.GetTargets(context.Background())
+
+	assert.Error(t, err)
+	assert.Nil(t, targets)
+}
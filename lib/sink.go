@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+//SinkFactory creates a sink from the configuration
+type SinkFactory func(*Config) (DNS, error)
+
+var sinkFactories = map[string]SinkFactory{}
+
+//RegisterSink makes a sink available to NewSinks by name, it is meant to be called from init
+func RegisterSink(name string, f SinkFactory) {
+	if _, found := sinkFactories[name]; found {
+		panic(fmt.Sprintf("sink %s registered twice", name))
+	}
+
+	sinkFactories[name] = f
+}
+
+//SinkNames returns the names of the registered sinks
+func SinkNames() []string {
+	names := []string{}
+
+	for n := range sinkFactories {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+//SinkStatus is the outcome of the last call made to a sink
+type SinkStatus struct {
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	Changes     int       `json:"changes"`
+}
+
+//SinkErrors holds the errors of the sinks that failed, keyed by sink name
+type SinkErrors map[string]error
+
+func (e SinkErrors) Error() string {
+	m := []string{}
+
+	for name, err := range e {
+		m = append(m, fmt.Sprintf("%s: %v", name, err))
+	}
+
+	sort.Strings(m)
+
+	return "sinks failed: " + strings.Join(m, "; ")
+}
+
+//Sinks fans targets out to several sinks, a failing sink doesn't stop the others
+type Sinks struct {
+	names  []string
+	sinks  map[string]DNS
+	mu     sync.RWMutex
+	status map[string]*SinkStatus
+}
+
+//NewSinks creates the named sinks from the configuration
+func NewSinks(names []string, c *Config) (*Sinks, error) {
+	s := &Sinks{sinks: map[string]DNS{}, status: map[string]*SinkStatus{}}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+
+		if _, found := s.sinks[name]; found {
+			continue
+		}
+
+		f, found := sinkFactories[name]
+
+		if !found {
+			return nil, fmt.Errorf("unknown sink %q, available sinks are %s", name, strings.Join(SinkNames(), ", "))
+		}
+
+		d, err := f(c)
+
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %v", name, err)
+		}
+
+		s.names = append(s.names, name)
+		s.sinks[name] = d
+		s.status[name] = &SinkStatus{}
+	}
+
+	if len(s.names) == 0 {
+		return nil, fmt.Errorf("no sinks configured, available sinks are %s", strings.Join(SinkNames(), ", "))
+	}
+
+	return s, nil
+}
+
+//Get returns the named sink
+func (s *Sinks) Get(name string) (DNS, bool) {
+	d, found := s.sinks[name]
+
+	return d, found
+}
+
+//Names returns the names of the enabled sinks
+func (s *Sinks) Names() []string {
+	return s.names
+}
+
+//Sync upserts targets into every sink
+func (s *Sinks) Sync(ctx context.Context, targets Targets) (int, error) {
+	return s.each("sync", func(d DNS) (int, error) { return d.Sync(ctx, targets) })
+}
+
+//Prune removes targets no longer registered from every sink
+func (s *Sinks) Prune(ctx context.Context, targets Targets) (int, error) {
+	return s.each("prune", func(d DNS) (int, error) { return d.Prune(ctx, targets) })
+}
+
+//RemoveAllManagedRecords removes managed records from every sink
+func (s *Sinks) RemoveAllManagedRecords(ctx context.Context) (int, error) {
+	return s.each("remove", func(d DNS) (int, error) { return d.RemoveAllManagedRecords(ctx) })
+}
+
+func (s *Sinks) each(op string, f func(DNS) (int, error)) (int, error) {
+	total := 0
+	errs := SinkErrors{}
+
+	for _, name := range s.names {
+		n, err := s.call(s.sinks[name], f)
+
+		s.record(name, op, n, err)
+
+		if err != nil {
+			glog.Errorf("sink %s %s failed: %v", name, op, err)
+			errs[name] = err
+			continue
+		}
+
+		total += n
+	}
+
+	if len(errs) > 0 {
+		return total, errs
+	}
+
+	return total, nil
+}
+
+//call isolates a sink so a panic fails only that sink
+func (s *Sinks) call(d DNS, f func(DNS) (int, error)) (n int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return f(d)
+}
+
+func (s *Sinks) record(name, op string, n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.status[name]
+	st.LastRun = time.Now()
+	st.Changes = n
+	st.LastError = ""
+
+	sinkRuns.WithLabelValues(name, op).Inc()
+
+	if err != nil {
+		st.LastError = err.Error()
+		sinkErrors.WithLabelValues(name, op).Inc()
+		sinkUp.WithLabelValues(name).Set(0)
+		return
+	}
+
+	st.LastSuccess = st.LastRun
+	sinkUp.WithLabelValues(name).Set(1)
+}
+
+//Status returns the status of every sink keyed by name
+func (s *Sinks) Status() map[string]SinkStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := map[string]SinkStatus{}
+
+	for name, st := range s.status {
+		m[name] = *st
+	}
+
+	return m
+}
+
+//ServeHTTP reports the status of every sink as JSON
+func (s *Sinks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(s.Status()); err != nil {
+		glog.Error(err)
+	}
+}
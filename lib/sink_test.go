@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubSink struct {
+	err    error
+	synced Targets
+}
+
+func (s *stubSink) Sync(ctx context.Context, t Targets) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	s.synced = t
+
+	return len(t), nil
+}
+
+func (s *stubSink) Prune(context.Context, Targets) (int, error) {
+	panic("prune exploded")
+}
+
+func (s *stubSink) RemoveAllManagedRecords(context.Context) (int, error) {
+	return 0, nil
+}
+
+var healthySink, failingSink = &stubSink{}, &stubSink{err: errors.New("unreachable")}
+
+func init() {
+	RegisterSink("stub_healthy", func(*Config) (DNS, error) { return healthySink, nil })
+	RegisterSink("stub_failing", func(*Config) (DNS, error) { return failingSink, nil })
+}
+
+func TestNewSinksUnknown(t *testing.T) {
+
+	_, err := NewSinks([]string{"route53", "nope"}, &Config{})
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "http_sd")
+}
+
+func TestSinksIsolateFailures(t *testing.T) {
+
+	s, err := NewSinks([]string{"stub_failing", "stub_healthy"}, &Config{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := Targets{"group1": {}}
+
+	n, err := s.Sync(context.Background(), targets)
+
+	assert.Equal(t, 1, n)
+	assert.Equal(t, targets, healthySink.synced, "a failing sink shouldn't stop the others")
+	assert.IsType(t, SinkErrors{}, err)
+	assert.Contains(t, err.(SinkErrors), "stub_failing")
+
+	status := s.Status()
+
+	assert.Equal(t, "unreachable", status["stub_failing"].LastError)
+	assert.True(t, status["stub_failing"].LastSuccess.IsZero())
+	assert.False(t, status["stub_healthy"].LastSuccess.IsZero())
+
+	_, err = s.Prune(context.Background(), targets)
+
+	assert.Contains(t, err.Error(), "panic: prune exploded")
+}
@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/golang/glog"
+)
+
+//DefaultTaskRefresh is how often every task is described again when ECSCluster.TaskRefresh is zero
+const DefaultTaskRefresh = 5 * time.Minute
+
+//describeTasksLimit is the most tasks DescribeTasks takes per call
+const describeTasksLimit = 100
+
+//getTasks returns the tasks of the cluster in the order ListTasks lists them, the tasks ECS failed to describe are returned as DiscoveryErrors.
+//Running tasks are cached by arn so only newly listed tasks are described, except every TaskRefresh when every task is described
+//again to catch changes, and tasks no longer listed are evicted. Tasks that aren't running yet are described until they are
+func (e *ECSCluster) getTasks(ctx context.Context) ([]*ecs.Task, error) {
+
+	now := clock(e.now)
+	refresh := e.TaskRefresh
+
+	if refresh <= 0 {
+		refresh = DefaultTaskRefresh
+	}
+
+	full := e.tasks == nil || now.Sub(e.tasksRefreshed) >= refresh
+
+	listed := []string{}
+	stale := []*string{}
+
+	err := e.ECSClient.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{Cluster: &e.Cluster}, func(o *ecs.ListTasksOutput, lastPage bool) bool {
+
+		for _, arn := range o.TaskArns {
+			listed = append(listed, *arn)
+
+			_, hit := e.tasks[*arn]
+			hit = hit && !full
+
+			recordCacheLookup("tasks", hit)
+
+			if !hit {
+				stale = append(stale, arn)
+			}
+		}
+
+		return !lastPage
+	})
+
+	if err != nil {
+		return nil, e.discoveryError("ListTasks", "", err)
+	}
+
+	described, failures, err := e.describeTasks(ctx, stale)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if e.tasks == nil {
+		e.tasks = map[string]*ecs.Task{}
+	}
+
+	if full {
+		e.tasksRefreshed = now
+	}
+
+	keep := map[string]bool{}
+
+	for _, arn := range listed {
+		keep[arn] = true
+	}
+
+	for arn := range e.tasks {
+		if !keep[arn] {
+			delete(e.tasks, arn)
+		}
+	}
+
+	// the group of a task that failed to describe is only known when it was described before
+	for _, f := range failures {
+		if t, found := e.tasks[f.ARN]; found {
+			f.Group = groupName(t)
+		}
+	}
+
+	// tasks that failed to describe or stopped since they were listed aren't served from the cache
+	for _, arn := range stale {
+		if _, found := described[*arn]; !found {
+			delete(e.tasks, *arn)
+		}
+	}
+
+	changed := 0
+
+	for arn, t := range described {
+		if c, found := e.tasks[arn]; !found || aws.Int64Value(c.Version) != aws.Int64Value(t.Version) {
+			changed++
+		}
+
+		// network bindings are only known once a task runs, so pending tasks aren't cached
+		if aws.StringValue(t.LastStatus) == ecs.DesiredStatusRunning {
+			e.tasks[arn] = t
+		} else {
+			delete(e.tasks, arn)
+		}
+	}
+
+	glog.V(1).Infof("cluster %s lists %d tasks, described %d, %d changed, full refresh %t", e.Cluster, len(listed), len(stale), changed, full)
+
+	tasks := []*ecs.Task{}
+
+	for _, arn := range listed {
+		if t, found := described[arn]; found {
+			tasks = append(tasks, t)
+		} else if t, found := e.tasks[arn]; found {
+			tasks = append(tasks, t)
+		}
+	}
+
+	if len(failures) > 0 {
+		return tasks, failures
+	}
+
+	return tasks, nil
+}
+
+//describeTasks describes tasks by arn in chunks of describeTasksLimit, returning the tasks described keyed by arn and
+//those ECS failed to describe. Tasks that stopped since they were listed are MISSING and simply left out
+func (e *ECSCluster) describeTasks(ctx context.Context, arns []*string) (map[string]*ecs.Task, DiscoveryErrors, error) {
+
+	chunks := chunk(arns, describeTasksLimit)
+	outputs := make([]*ecs.DescribeTasksOutput, len(chunks))
+
+	err := e.describeConcurrently(ctx, chunks, func(ctx context.Context, i int, arns []*string) error {
+		o, err := e.ECSClient.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+			Cluster: &e.Cluster,
+			Tasks:   arns,
+			Include: []*string{aws.String(ecs.TaskFieldTags)},
+		})
+
+		outputs[i] = o
+
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, e.discoveryError("DescribeTasks", "", err)
+	}
+
+	tasks := map[string]*ecs.Task{}
+	var failures DiscoveryErrors
+
+	for _, o := range outputs {
+		for _, t := range o.Tasks {
+			tasks[aws.StringValue(t.TaskArn)] = t
+		}
+
+		for _, f := range o.Failures {
+			if aws.StringValue(f.Reason) == failureMissing {
+				glog.V(1).Infof("task %s of cluster %s stopped since it was listed", aws.StringValue(f.Arn), e.Cluster)
+				continue
+			}
+
+			failures = append(failures, e.discoveryError("DescribeTasks", aws.StringValue(f.Arn), errors.New(aws.StringValue(f.Reason))))
+		}
+	}
+
+	return tasks, failures, nil
+}
@@ -0,0 +1,349 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/golang/glog"
+)
+
+//hostedZoneComment marks the hosted zones created by ecs-dns
+const hostedZoneComment = "managed by ecs-dns"
+
+//Route53Api contains the functions necessary to find and create hosted zones
+type Route53Api interface {
+	ListHostedZonesByNameWithContext(aws.Context, *route53.ListHostedZonesByNameInput, ...request.Option) (*route53.ListHostedZonesByNameOutput, error)
+	GetHostedZoneWithContext(aws.Context, *route53.GetHostedZoneInput, ...request.Option) (*route53.GetHostedZoneOutput, error)
+	CreateHostedZoneWithContext(aws.Context, *route53.CreateHostedZoneInput, ...request.Option) (*route53.CreateHostedZoneOutput, error)
+	AssociateVPCWithHostedZoneWithContext(aws.Context, *route53.AssociateVPCWithHostedZoneInput, ...request.Option) (*route53.AssociateVPCWithHostedZoneOutput, error)
+}
+
+//HostedZones looks up, verifies and creates the Route53 hosted zone of a domain
+type HostedZones struct {
+	Client Route53Api
+	//VPCs the clusters run in, private zones must be associated with one of them to be used and are associated in their region
+	VPCs []VPC
+}
+
+//Find returns the id of the closest hosted zone enclosing domain.
+//A private zone associated with the cluster VPCs is preferred, as it shadows the public zone inside them, and only private zones are considered when private is set
+func (h *HostedZones) Find(ctx context.Context, domain string, private bool) (string, error) {
+
+	for name := zoneName(domain); name != ""; name = parentDomain(name) {
+
+		zones, err := h.byName(ctx, name)
+
+		if err != nil {
+			return "", err
+		}
+
+		if len(zones) == 0 {
+			continue
+		}
+
+		privateIDs, publicIDs := []string{}, []string{}
+
+		for _, z := range zones {
+			if !aws.BoolValue(z.Config.PrivateZone) {
+				publicIDs = append(publicIDs, zoneID(z))
+				continue
+			}
+
+			associated, err := h.associated(ctx, zoneID(z))
+
+			if err != nil {
+				return "", err
+			}
+
+			if associated {
+				privateIDs = append(privateIDs, zoneID(z))
+			}
+		}
+
+		if len(privateIDs) > 0 || private {
+			return pickZone(privateIDs, "private", name)
+		}
+
+		if len(publicIDs) == 0 {
+			return "", fmt.Errorf("the private hosted zones of %s aren't associated with the cluster vpcs %s", name, strings.Join(VPCIDs(h.VPCs), ", "))
+		}
+
+		return pickZone(publicIDs, "public", name)
+	}
+
+	return "", fmt.Errorf("no %s hosted zone found for %s", zoneKind(private), domain)
+}
+
+//Verify returns an error unless the hosted zone id encloses domain and, when private, is associated with the cluster VPCs.
+//A zone that only is an ancestor of the closest zone enclosing domain, as Find resolves it, is refused as its records would be hidden
+func (h *HostedZones) Verify(ctx context.Context, id, domain string) error {
+
+	o, err := h.Client.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(id)})
+
+	if err != nil {
+		return err
+	}
+
+	name := strings.ToLower(aws.StringValue(o.HostedZone.Name))
+
+	if !isSubdomain(zoneName(domain), name) {
+		return fmt.Errorf("hosted zone %s hosts %s, not %s", id, name, domain)
+	}
+
+	private := aws.BoolValue(o.HostedZone.Config.PrivateZone)
+
+	if private && len(h.VPCs) > 0 && !h.anyAssociated(o.VPCs) {
+		return fmt.Errorf("private hosted zone %s isn't associated with the cluster vpcs %s", id, strings.Join(VPCIDs(h.VPCs), ", "))
+	}
+
+	closer, err := h.closer(ctx, zoneName(domain), name, private)
+
+	if err != nil {
+		return err
+	}
+
+	if closer != "" {
+		return fmt.Errorf("hosted zone %s hosts %s, but %s is hosted by the closer zone %s", id, name, domain, closer)
+	}
+
+	return nil
+}
+
+//closer returns the id of a hosted zone between domain and its ancestor zone that Find would pick instead, empty when there's none.
+//Private zones associated with the cluster VPCs shadow any zone, public zones only shadow public ones
+func (h *HostedZones) closer(ctx context.Context, domain, zone string, private bool) (string, error) {
+
+	for name := domain; name != zone && name != ""; name = parentDomain(name) {
+
+		zones, err := h.byName(ctx, name)
+
+		if err != nil {
+			return "", err
+		}
+
+		for _, z := range zones {
+			if !aws.BoolValue(z.Config.PrivateZone) {
+				if !private {
+					return zoneID(z), nil
+				}
+
+				continue
+			}
+
+			associated, err := h.associated(ctx, zoneID(z))
+
+			if err != nil {
+				return "", err
+			}
+
+			if associated {
+				return zoneID(z), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+//EnsurePrivate returns the id of the private hosted zone named domain, creating it if missing, and associates it with the cluster VPCs
+func (h *HostedZones) EnsurePrivate(ctx context.Context, domain string) (string, error) {
+
+	if len(h.VPCs) == 0 {
+		return "", fmt.Errorf("no vpcs to associate the private hosted zone %s with", domain)
+	}
+
+	zones, err := h.byName(ctx, zoneName(domain))
+
+	if err != nil {
+		return "", err
+	}
+
+	ids := []string{}
+
+	for _, z := range zones {
+		if aws.BoolValue(z.Config.PrivateZone) {
+			ids = append(ids, zoneID(z))
+		}
+	}
+
+	if len(ids) == 0 {
+		return h.create(ctx, domain)
+	}
+
+	id, err := pickZone(ids, "private", domain)
+
+	if err != nil {
+		return "", err
+	}
+
+	o, err := h.Client.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(id)})
+
+	if err != nil {
+		return "", err
+	}
+
+	associated := map[string]bool{}
+
+	for _, v := range o.VPCs {
+		associated[aws.StringValue(v.VPCId)] = true
+	}
+
+	for _, vpc := range h.VPCs {
+		if associated[vpc.ID] {
+			continue
+		}
+
+		if err := h.associate(ctx, id, vpc); err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+//byName returns the hosted zones named name
+func (h *HostedZones) byName(ctx context.Context, name string) ([]*route53.HostedZone, error) {
+
+	input := &route53.ListHostedZonesByNameInput{DNSName: aws.String(name)}
+	zones := []*route53.HostedZone{}
+
+	for {
+		o, err := h.Client.ListHostedZonesByNameWithContext(ctx, input)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// zones are listed in name order starting at DNSName, so the first other name ends the matches
+		for _, z := range o.HostedZones {
+			if strings.ToLower(aws.StringValue(z.Name)) != name {
+				return zones, nil
+			}
+
+			zones = append(zones, z)
+		}
+
+		if !aws.BoolValue(o.IsTruncated) {
+			return zones, nil
+		}
+
+		input.DNSName, input.HostedZoneId = o.NextDNSName, o.NextHostedZoneId
+	}
+}
+
+//associated reports whether the private zone id is associated with one of the cluster VPCs, or true when they're unknown
+func (h *HostedZones) associated(ctx context.Context, id string) (bool, error) {
+
+	if len(h.VPCs) == 0 {
+		return true, nil
+	}
+
+	o, err := h.Client.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(id)})
+
+	if err != nil {
+		return false, err
+	}
+
+	return h.anyAssociated(o.VPCs), nil
+}
+
+func (h *HostedZones) anyAssociated(vpcs []*route53.VPC) bool {
+	for _, v := range vpcs {
+		for _, vpc := range h.VPCs {
+			if aws.StringValue(v.VPCId) == vpc.ID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (h *HostedZones) create(ctx context.Context, domain string) (string, error) {
+
+	glog.Infof("Creating private hosted zone %s in %s", domain, h.VPCs[0].ID)
+
+	o, err := h.Client.CreateHostedZoneWithContext(ctx, &route53.CreateHostedZoneInput{
+		Name:            aws.String(domain),
+		CallerReference: aws.String(fmt.Sprintf("ecs-dns-%d", time.Now().UnixNano())),
+		HostedZoneConfig: &route53.HostedZoneConfig{
+			Comment:     aws.String(hostedZoneComment),
+			PrivateZone: aws.Bool(true),
+		},
+		VPC: &route53.VPC{VPCId: aws.String(h.VPCs[0].ID), VPCRegion: aws.String(h.VPCs[0].Region)},
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	id := zoneID(o.HostedZone)
+
+	for _, vpc := range h.VPCs[1:] {
+		if err := h.associate(ctx, id, vpc); err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+func (h *HostedZones) associate(ctx context.Context, id string, vpc VPC) error {
+
+	glog.Infof("Associating %s in %s with hosted zone %s", vpc.ID, vpc.Region, id)
+
+	_, err := h.Client.AssociateVPCWithHostedZoneWithContext(ctx, &route53.AssociateVPCWithHostedZoneInput{
+		HostedZoneId: aws.String(id),
+		VPC:          &route53.VPC{VPCId: aws.String(vpc.ID), VPCRegion: aws.String(vpc.Region)},
+	})
+
+	return err
+}
+
+func pickZone(ids []string, kind, name string) (string, error) {
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("no %s hosted zone found for %s", kind, name)
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("%d %s hosted zones found for %s: %s, pass --zone", len(ids), kind, name, strings.Join(ids, ", "))
+	}
+}
+
+//zoneID strips the /hostedzone/ prefix from the id of z
+func zoneID(z *route53.HostedZone) string {
+	return strings.TrimPrefix(aws.StringValue(z.Id), "/hostedzone/")
+}
+
+func zoneKind(private bool) string {
+	if private {
+		return "private"
+	}
+
+	return "public"
+}
+
+func zoneName(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, ".") + ".")
+}
+
+//parentDomain returns name without its first label, or empty for a top level domain
+func parentDomain(name string) string {
+	i := strings.Index(name, ".")
+
+	if i < 0 || i == len(name)-1 {
+		return ""
+	}
+
+	return name[i+1:]
+}
+
+func isSubdomain(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/stretchr/testify/assert"
+)
+
+//stubRoute53Client keeps hosted zones and their vpcs in memory
+type stubRoute53Client struct {
+	zones []*route53.HostedZone
+	vpcs  map[string][]VPC
+}
+
+func newStubRoute53Client() *stubRoute53Client {
+	return &stubRoute53Client{
+		zones: []*route53.HostedZone{
+			{Id: aws.String("/hostedzone/PUBLIC1"), Name: aws.String("production1.ecs."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			{Id: aws.String("/hostedzone/OTHER1"), Name: aws.String("production2.ecs."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+		},
+		vpcs: map[string][]VPC{},
+	}
+}
+
+func (s *stubRoute53Client) ListHostedZonesByNameWithContext(ctx aws.Context, i *route53.ListHostedZonesByNameInput, opts ...request.Option) (*route53.ListHostedZonesByNameOutput, error) {
+	zones := append([]*route53.HostedZone{}, s.zones...)
+
+	sort.SliceStable(zones, func(i, j int) bool { return *zones[i].Name < *zones[j].Name })
+
+	o := &route53.ListHostedZonesByNameOutput{}
+
+	for _, z := range zones {
+		if *z.Name >= *i.DNSName {
+			o.HostedZones = append(o.HostedZones, z)
+		}
+	}
+
+	return o, nil
+}
+
+func (s *stubRoute53Client) GetHostedZoneWithContext(ctx aws.Context, i *route53.GetHostedZoneInput, opts ...request.Option) (*route53.GetHostedZoneOutput, error) {
+	for _, z := range s.zones {
+		if zoneID(z) == *i.Id {
+			o := &route53.GetHostedZoneOutput{HostedZone: z}
+
+			for _, v := range s.vpcs[*i.Id] {
+				o.VPCs = append(o.VPCs, &route53.VPC{VPCId: aws.String(v.ID), VPCRegion: aws.String(v.Region)})
+			}
+
+			return o, nil
+		}
+	}
+
+	return nil, fmt.Errorf("NoSuchHostedZone: %s", *i.Id)
+}
+
+func (s *stubRoute53Client) CreateHostedZoneWithContext(ctx aws.Context, i *route53.CreateHostedZoneInput, opts ...request.Option) (*route53.CreateHostedZoneOutput, error) {
+	id := fmt.Sprintf("CREATED%d", len(s.zones))
+	z := &route53.HostedZone{Id: aws.String("/hostedzone/" + id), Name: aws.String(*i.Name + "."), Config: i.HostedZoneConfig}
+
+	s.zones = append(s.zones, z)
+	s.vpcs[id] = []VPC{{ID: *i.VPC.VPCId, Region: *i.VPC.VPCRegion}}
+
+	return &route53.CreateHostedZoneOutput{HostedZone: z}, nil
+}
+
+func (s *stubRoute53Client) AssociateVPCWithHostedZoneWithContext(ctx aws.Context, i *route53.AssociateVPCWithHostedZoneInput, opts ...request.Option) (*route53.AssociateVPCWithHostedZoneOutput, error) {
+	s.vpcs[*i.HostedZoneId] = append(s.vpcs[*i.HostedZoneId], VPC{ID: *i.VPC.VPCId, Region: *i.VPC.VPCRegion})
+
+	return &route53.AssociateVPCWithHostedZoneOutput{}, nil
+}
+
+func TestHostedZonesFind(t *testing.T) {
+
+	h := &HostedZones{Client: newStubRoute53Client()}
+
+	id, err := h.Find(context.Background(), "production1.ecs", false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "PUBLIC1", id)
+
+	// the closest enclosing zone hosts subdomains
+	id, err = h.Find(context.Background(), "services.production1.ecs", false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "PUBLIC1", id)
+
+	_, err = h.Find(context.Background(), "production1.ecs", true)
+
+	assert.Error(t, err)
+
+	_, err = h.Find(context.Background(), "production3.ecs", false)
+
+	assert.Error(t, err)
+}
+
+func TestHostedZonesFindPrivate(t *testing.T) {
+
+	c := newStubRoute53Client()
+	c.zones = append(c.zones,
+		&route53.HostedZone{Id: aws.String("/hostedzone/PRIVATE1"), Name: aws.String("production1.ecs."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+		&route53.HostedZone{Id: aws.String("/hostedzone/PRIVATE2"), Name: aws.String("production1.ecs."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+	)
+	c.vpcs["PRIVATE1"] = []VPC{{ID: "vpc-1", Region: "us-east-1"}}
+	c.vpcs["PRIVATE2"] = []VPC{{ID: "vpc-2", Region: "us-east-1"}}
+
+	// the private zone associated with the cluster vpc shadows the public zone
+	h := &HostedZones{Client: c, VPCs: []VPC{{ID: "vpc-2", Region: "us-east-1"}}}
+
+	id, err := h.Find(context.Background(), "production1.ecs", false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "PRIVATE2", id)
+
+	h.VPCs = []VPC{{ID: "vpc-3", Region: "us-east-1"}}
+
+	id, err = h.Find(context.Background(), "production1.ecs", false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "PUBLIC1", id)
+
+	_, err = h.Find(context.Background(), "production1.ecs", true)
+
+	assert.Error(t, err)
+
+	// without vpcs two private zones can't be told apart
+	h.VPCs = nil
+
+	_, err = h.Find(context.Background(), "production1.ecs", true)
+
+	assert.Error(t, err)
+}
+
+func TestHostedZonesVerify(t *testing.T) {
+
+	c := newStubRoute53Client()
+	h := &HostedZones{Client: c, VPCs: []VPC{{ID: "vpc-1", Region: "us-east-1"}}}
+
+	assert.NoError(t, h.Verify(context.Background(), "PUBLIC1", "production1.ecs"))
+	assert.NoError(t, h.Verify(context.Background(), "PUBLIC1", "services.production1.ecs."))
+	assert.Error(t, h.Verify(context.Background(), "PUBLIC1", "production2.ecs"))
+	assert.Error(t, h.Verify(context.Background(), "MISSING", "production1.ecs"))
+
+	// OTHER1 is private and not associated with vpc-1
+	assert.Error(t, h.Verify(context.Background(), "OTHER1", "production2.ecs"))
+
+	c.vpcs["OTHER1"] = []VPC{{ID: "vpc-1", Region: "us-east-1"}}
+
+	assert.NoError(t, h.Verify(context.Background(), "OTHER1", "production2.ecs"))
+
+	// records of a delegated zone written into its parent would be hidden by the delegation
+	c.zones = append(c.zones, &route53.HostedZone{Id: aws.String("/hostedzone/SERVICES1"), Name: aws.String("services.production1.ecs."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}})
+
+	assert.Error(t, h.Verify(context.Background(), "PUBLIC1", "services.production1.ecs"))
+	assert.Error(t, h.Verify(context.Background(), "PUBLIC1", "api.services.production1.ecs"))
+	assert.NoError(t, h.Verify(context.Background(), "SERVICES1", "api.services.production1.ecs"))
+	assert.NoError(t, h.Verify(context.Background(), "PUBLIC1", "other.production1.ecs"))
+
+	// a public zone doesn't shadow a private one inside the vpcs
+	c.zones = append(c.zones, &route53.HostedZone{Id: aws.String("/hostedzone/SERVICES2"), Name: aws.String("services.production2.ecs."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}})
+
+	assert.NoError(t, h.Verify(context.Background(), "OTHER1", "services.production2.ecs"))
+}
+
+func TestHostedZonesEnsurePrivate(t *testing.T) {
+
+	c := newStubRoute53Client()
+	h := &HostedZones{Client: c, VPCs: []VPC{{ID: "vpc-1", Region: "us-east-1"}, {ID: "vpc-2", Region: "us-east-1"}}}
+
+	id, err := h.EnsurePrivate(context.Background(), "production1.ecs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []VPC{{ID: "vpc-1", Region: "us-east-1"}, {ID: "vpc-2", Region: "us-east-1"}}, c.vpcs[id])
+	assert.Len(t, c.zones, 3)
+
+	// the existing zone is reused and only missing vpcs are associated, each in the region of its cluster
+	h.VPCs = []VPC{{ID: "vpc-2", Region: "us-east-1"}, {ID: "vpc-3", Region: "us-west-2"}}
+
+	again, err := h.EnsurePrivate(context.Background(), "production1.ecs")
+
+	assert.NoError(t, err)
+	assert.Equal(t, id, again)
+	assert.Equal(t, []VPC{{ID: "vpc-1", Region: "us-east-1"}, {ID: "vpc-2", Region: "us-east-1"}, {ID: "vpc-3", Region: "us-west-2"}}, c.vpcs[id])
+	assert.Len(t, c.zones, 3)
+}